@@ -0,0 +1,220 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+)
+
+// The largest parameter number SQLite accepts in ?NNN.
+const maxBindParameterNumber = 32766
+
+// The bind parameters of a statement: the number of parameters and the
+// position of each named parameter, numbered the way SQLite numbers them.
+type bindParameters struct {
+	count int
+	names map[string]int
+}
+
+// Find the bind parameters in a statement with a small SQLite tokenizer, so
+// placeholders inside string literals, quoted identifiers and comments are
+// skipped. A "?" takes the position after the largest one so far, "?NNN"
+// takes position NNN and a named parameter (:name, @name or $name) takes the
+// position after the largest one the first time it appears and keeps it when
+// it is repeated.
+func parseBindParameters(sql string) (*bindParameters, error) {
+	parameters := &bindParameters{
+		names: map[string]int{},
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(sql, i, c)
+		case c == '[':
+			i = skipQuoted(sql, i, ']')
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i += 2
+
+			for i < len(sql) && !(sql[i] == '*' && i+1 < len(sql) && sql[i+1] == '/') {
+				i++
+			}
+
+			i = min(i+2, len(sql))
+		case c == '?':
+			end := i + 1
+
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+
+			if end == i+1 {
+				parameters.count++
+			} else {
+				number, err := strconv.Atoi(sql[i+1 : end])
+
+				if err != nil || number < 1 || number > maxBindParameterNumber {
+					return nil, fmt.Errorf("invalid parameter number: %s", sql[i:end])
+				}
+
+				parameters.count = max(parameters.count, number)
+			}
+
+			i = end
+		case c == ':' || c == '@' || c == '$':
+			end := scanParameterName(sql, i)
+
+			if end == i+1 {
+				// A lone prefix character is not a parameter.
+				i++
+				continue
+			}
+
+			name := sql[i:end]
+
+			if _, ok := parameters.names[name]; !ok {
+				parameters.count++
+				parameters.names[name] = parameters.count
+			}
+
+			i = end
+		case isIdentifierChar(c):
+			// Skip whole identifiers, keywords and numbers since "$" is also
+			// allowed inside an identifier.
+			for i < len(sql) && (isIdentifierChar(sql[i]) || sql[i] == '$') {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+
+	return parameters, nil
+}
+
+// Skip a quoted string or identifier starting at i. A doubled closing quote is
+// an escaped quote. Returns the index after the closing quote.
+func skipQuoted(sql string, i int, quote byte) int {
+	for i++; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+
+		if quote != ']' && i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+
+		return i + 1
+	}
+
+	return len(sql)
+}
+
+// Scan a parameter name starting at the prefix character at i. Like SQLite, a
+// name may contain "::" and end with a parenthesized suffix.
+func scanParameterName(sql string, i int) int {
+	end := i + 1
+
+	for end < len(sql) {
+		c := sql[end]
+
+		switch {
+		case isIdentifierChar(c) || c == '$':
+			end++
+		case c == ':' && end+1 < len(sql) && sql[end+1] == ':' && end > i+1:
+			end += 2
+		case c == '(' && end > i+1:
+			for end < len(sql) && sql[end] != ')' {
+				end++
+			}
+
+			return min(end+1, len(sql))
+		default:
+			return end
+		}
+	}
+
+	return end
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 0x80 || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Find the position of a named argument. The name may be given with or
+// without its prefix, without one it matches :name, @name and $name.
+func (p *bindParameters) position(name string) (int, bool) {
+	if position, ok := p.names[name]; ok {
+		return position, true
+	}
+
+	for _, prefix := range []string{":", "@", "$"} {
+		if position, ok := p.names[prefix+name]; ok {
+			return position, true
+		}
+	}
+
+	return 0, false
+}
+
+// Prepare the parameters for the statement. Named arguments are resolved to
+// the position of their parameter, all other arguments are bound by their
+// ordinal.
+func (p *bindParameters) prepare(args []driver.NamedValue) ([]Parameter, error) {
+	if !hasNamedValues(args) {
+		return prepareParameters(args)
+	}
+
+	parameters := make([]Parameter, p.count)
+	bound := make([]bool, p.count)
+
+	for _, arg := range args {
+		position := arg.Ordinal
+
+		if arg.Name != "" {
+			var ok bool
+
+			position, ok = p.position(arg.Name)
+
+			if !ok {
+				return nil, fmt.Errorf("named parameter not found in statement: %s", arg.Name)
+			}
+		}
+
+		if position < 1 || position > p.count {
+			return nil, fmt.Errorf("parameter position out of range: %d", position)
+		}
+
+		if bound[position-1] {
+			return nil, fmt.Errorf("parameter %d is bound more than once", position)
+		}
+
+		parameter, err := newParameter(arg.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parameters[position-1] = parameter
+		bound[position-1] = true
+	}
+
+	for i, ok := range bound {
+		if !ok {
+			return nil, fmt.Errorf("missing argument for parameter %d", i+1)
+		}
+	}
+
+	return parameters, nil
+}
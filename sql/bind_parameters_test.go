@@ -0,0 +1,92 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestParseBindParameters(t *testing.T) {
+	tests := []struct {
+		sql   string
+		count int
+		names map[string]int
+	}{
+		{"SELECT 1", 0, map[string]int{}},
+		{"SELECT ?, ?", 2, map[string]int{}},
+		{"SELECT ?2, ?1, ?2", 2, map[string]int{}},
+		{"SELECT ?3, ?", 4, map[string]int{}},
+		{"SELECT :id, @name, $value", 3, map[string]int{":id": 1, "@name": 2, "$value": 3}},
+		{"SELECT :id, :id, ?", 2, map[string]int{":id": 1}},
+		{"SELECT ?, :id, ?5, :other", 6, map[string]int{":id": 2, ":other": 6}},
+		{"SELECT '?', ':id', 'it''s ?' WHERE a = ?", 1, map[string]int{}},
+		{`SELECT "?", "a "" :b", [c?], ` + "`:d`" + ` FROM t WHERE x = :x`, 1, map[string]int{":x": 1}},
+		{"SELECT 1 -- ? :id\nWHERE a = ?", 1, map[string]int{}},
+		{"SELECT /* ? :id */ ? /* unterminated ?", 1, map[string]int{}},
+		{"SELECT data ->> '$.name' FROM t WHERE id = $id", 1, map[string]int{"$id": 1}},
+		{"SELECT a$b, $a::b, $c(x y) FROM t", 2, map[string]int{"$a::b": 1, "$c(x y)": 2}},
+		{"SELECT '12:30', ':' || ?", 1, map[string]int{}},
+	}
+
+	for _, test := range tests {
+		parameters, err := parseBindParameters(test.sql)
+
+		if err != nil {
+			t.Fatalf("%q: %v", test.sql, err)
+		}
+
+		if parameters.count != test.count {
+			t.Errorf("%q: expected %d parameters, got %d", test.sql, test.count, parameters.count)
+		}
+
+		if !reflect.DeepEqual(parameters.names, test.names) {
+			t.Errorf("%q: expected names %v, got %v", test.sql, test.names, parameters.names)
+		}
+	}
+
+	for _, sql := range []string{"SELECT ?0", "SELECT ?32767"} {
+		if _, err := parseBindParameters(sql); err == nil {
+			t.Errorf("%q: expected an error", sql)
+		}
+	}
+}
+
+func TestBindParametersPrepare(t *testing.T) {
+	parameters, err := parseBindParameters("SELECT ?, :id, @name, :id")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := parameters.prepare([]driver.NamedValue{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Name: "name", Value: "litebase"},
+		{Ordinal: 3, Name: ":id", Value: int64(2)},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Parameter{
+		{Type: ParameterTypeInteger, Value: int64(1)},
+		{Type: ParameterTypeInteger, Value: int64(2)},
+		{Type: ParameterTypeText, Value: "litebase"},
+	}
+
+	if !reflect.DeepEqual(prepared, expected) {
+		t.Fatalf("Expected %v, got %v", expected, prepared)
+	}
+
+	invalid := [][]driver.NamedValue{
+		{{Ordinal: 1, Name: "missing", Value: int64(1)}},
+		{{Ordinal: 1, Value: int64(1)}, {Ordinal: 2, Name: "id", Value: int64(2)}},
+		{{Ordinal: 1, Value: int64(1)}, {Ordinal: 2, Name: "id", Value: int64(2)}, {Ordinal: 3, Name: "@id", Value: int64(3)}},
+	}
+
+	for _, args := range invalid {
+		if _, err := parameters.prepare(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package sql
 
+import (
+	"reflect"
+)
+
 type ColumnType int
 
 const (
@@ -11,12 +15,58 @@ const (
 	ColumnTypeNull    ColumnType = 5
 )
 
+// ColumnNullability reports whether a column may contain NULL values. It is
+// only known when the column comes straight from a table.
+type ColumnNullability byte
+
+const (
+	ColumnNullabilityUnknown ColumnNullability = 0
+	ColumnNullable           ColumnNullability = 1
+	ColumnNotNull            ColumnNullability = 2
+)
+
 type ColumnDefinition struct {
-	ColumnName string     `json:"name"`
-	ColumnType ColumnType `json:"type"`
+	ColumnName   string            `json:"name"`
+	ColumnType   ColumnType        `json:"type"`
+	DeclaredType string            `json:"declaredType"`
+	Nullability  ColumnNullability `json:"nullability"`
 }
 
 type Column struct {
 	Type  ColumnType
 	Value []byte
 }
+
+// The name of the storage class.
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnTypeInteger:
+		return "INTEGER"
+	case ColumnTypeFloat:
+		return "REAL"
+	case ColumnTypeText:
+		return "TEXT"
+	case ColumnTypeBlob:
+		return "BLOB"
+	case ColumnTypeNull:
+		return "NULL"
+	default:
+		return ""
+	}
+}
+
+// The Go type values of the storage class are decoded into.
+func (t ColumnType) ScanType() reflect.Type {
+	switch t {
+	case ColumnTypeInteger:
+		return reflect.TypeOf(int64(0))
+	case ColumnTypeFloat:
+		return reflect.TypeOf(float64(0))
+	case ColumnTypeText:
+		return reflect.TypeOf("")
+	case ColumnTypeBlob:
+		return reflect.TypeOf([]byte(nil))
+	default:
+		return reflect.TypeOf((*any)(nil)).Elem()
+	}
+}
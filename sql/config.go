@@ -0,0 +1,207 @@
+package sql
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// The time to wait for the server to accept a new connection when
+	// Config.ConnectTimeout is not set.
+	DefaultConnectTimeout = 5 * time.Second
+
+	// The number of connections in the pool when Config.MaxConnections is not
+	// set.
+	DefaultMaxConnections = 10
+
+	// The number of queries that run at once over a connection when
+	// Config.MaxInFlight is not set.
+	DefaultMaxInFlight = 50
+
+	// The number of times in a row a connection tries to reconnect to the
+	// server when Config.MaxReconnectAttempts is not set.
+	DefaultMaxReconnectAttempts = 5
+
+	// The time closing a connector waits for running queries when
+	// Config.CloseTimeout is not set.
+	DefaultCloseTimeout = 10 * time.Second
+
+	// The time committing or rolling back a transaction waits for the server
+	// when Config.QueryTimeout is not set.
+	DefaultTransactionEndTimeout = 30 * time.Second
+)
+
+// Config configures a Connector created with NewConnector. The connection
+// string options of sql.Open map to the fields noted below.
+type Config struct {
+	// The base URL of the database, e.g. https://localhost:8080/database
+	// (url, or the host and path of a litebase:// URL).
+	URL string
+
+	// The access key requests are signed with (accessKeyId and
+	// accessKeySecret, or the user info of a litebase:// URL).
+	AccessKeyID     string
+	AccessKeySecret string
+
+	// Called for the access key every time a connection to the server is
+	// opened, in place of AccessKeyID and AccessKeySecret. Use it to rotate
+	// keys without reopening the database.
+	Credentials func(ctx context.Context) (accessKeyID, accessKeySecret string, err error)
+
+	// The most connections to the server the pool opens, DefaultMaxConnections
+	// when zero (maxConns).
+	MaxConnections int
+
+	// The number of connections opened when the connector is created, so the
+	// first queries do not wait for the handshake (minConns).
+	MinConnections int
+
+	// The most queries that run at once over one connection,
+	// DefaultMaxInFlight when zero (maxInFlight).
+	MaxInFlight int
+
+	// The time to wait for the server to accept a new connection,
+	// DefaultConnectTimeout when zero.
+	ConnectTimeout time.Duration
+
+	// The number of times in a row a connection tries to reconnect after its
+	// stream to the server failed before it is removed from the pool,
+	// DefaultMaxReconnectAttempts when zero and no reconnects when negative.
+	MaxReconnectAttempts int
+
+	// The timeout of queries whose context has no deadline, no timeout when
+	// zero (timeout).
+	QueryTimeout time.Duration
+
+	// The time closing the connector waits for running queries to finish
+	// before the connections are closed, DefaultCloseTimeout when zero.
+	CloseTimeout time.Duration
+
+	// The TLS configuration of the default HTTP transport. It cannot be
+	// combined with Transport.
+	TLSConfig *tls.Config
+
+	// The HTTP transport connections to the server are opened with, an
+	// http.Transport with TLSConfig when nil.
+	Transport http.RoundTripper
+
+	// The logger connection errors are written to, log.Default() when nil.
+	Logger *log.Logger
+
+	// Functions called around every query.
+	Hooks Hooks
+
+	// Read the rows of queries through a server-side cursor this many rows at
+	// a time, streamed without a cursor when zero (fetchSize).
+	FetchSize int
+
+	// The encoding of time.Time arguments, TimeEncodingText when empty
+	// (timeEncoding).
+	TimeEncoding TimeEncoding
+}
+
+// Hooks are called around every query a connector runs, any of them may be
+// nil. They are called from the goroutine running the query.
+type Hooks struct {
+	// Called before a query is sent. The returned context is the one the query
+	// runs with and is passed to AfterQuery, e.g. to carry a tracing span.
+	BeforeQuery func(ctx context.Context, query Query) context.Context
+
+	// Called once the server has answered a query, or sending it failed. For
+	// queries that return rows it is called when the first rows arrive.
+	AfterQuery func(ctx context.Context, query Query, duration time.Duration, err error)
+}
+
+// Check the configuration and fill in the defaults of the fields left empty.
+func (c Config) withDefaults() (Config, error) {
+	if c.URL == "" {
+		return c, errors.New("url is required")
+	}
+
+	// The parse error is not wrapped, it quotes the URL with any user info.
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return c, errors.New("url must be an http or https URL with a host")
+	}
+
+	if c.Credentials == nil {
+		if c.AccessKeyID == "" {
+			return c, errors.New("accessKeyId is required")
+		}
+
+		if c.AccessKeySecret == "" {
+			return c, errors.New("accessKeySecret is required")
+		}
+	}
+
+	if c.TLSConfig != nil && c.Transport != nil {
+		return c, errors.New("TLSConfig cannot be used with a custom Transport")
+	}
+
+	if c.MaxConnections < 0 {
+		return c, errors.New("MaxConnections cannot be negative")
+	}
+
+	if c.MaxConnections == 0 {
+		c.MaxConnections = DefaultMaxConnections
+	}
+
+	if c.MinConnections < 0 {
+		return c, errors.New("MinConnections cannot be negative")
+	}
+
+	if c.MinConnections > c.MaxConnections {
+		return c, errors.New("MinConnections cannot be greater than MaxConnections")
+	}
+
+	if c.MaxInFlight < 0 {
+		return c, errors.New("MaxInFlight cannot be negative")
+	}
+
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = DefaultMaxInFlight
+	}
+
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = DefaultConnectTimeout
+	}
+
+	if c.CloseTimeout == 0 {
+		c.CloseTimeout = DefaultCloseTimeout
+	}
+
+	if c.MaxReconnectAttempts == 0 {
+		c.MaxReconnectAttempts = DefaultMaxReconnectAttempts
+	}
+
+	if c.FetchSize < 0 {
+		return c, errors.New("FetchSize cannot be negative")
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Default()
+	}
+
+	timeEncoding, err := ParseTimeEncoding(string(c.TimeEncoding))
+
+	if err != nil {
+		return c, err
+	}
+
+	c.TimeEncoding = timeEncoding
+
+	return c, nil
+}
+
+// Get the access key to sign requests with.
+func (c Config) credentials(ctx context.Context) (string, string, error) {
+	if c.Credentials != nil {
+		return c.Credentials(ctx)
+	}
+
+	return c.AccessKeyID, c.AccessKeySecret, nil
+}
@@ -5,7 +5,6 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -14,62 +13,85 @@ import (
 )
 
 type Conn struct {
-	url         string
+	config      Config
 	pool        *ConnectionPool
 	transaction *Transaction
 }
 
-func NewConn(url string, pool *ConnectionPool) *Conn {
+func NewConn(config Config, pool *ConnectionPool) *Conn {
 	return &Conn{
-		pool: pool,
-		url:  url,
+		config: config,
+		pool:   pool,
 	}
 }
 
+// Deprecated: Use BeginTx instead.
 func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.transaction != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+
+	mode, err := transactionMode(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// Get a connection
-	connection, err := c.pool.Get()
+	connection, err := c.pool.Get(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	c.transaction = NewTransaction(
-		uuid.NewString(),
-		c.pool,
-		connection,
-	)
+	transaction, err := BeginTransaction(ctx, c, c.pool, connection, mode)
+
+	if err != nil {
+		c.pool.Put(connection)
+		return nil, err
+	}
+
+	c.transaction = transaction
 
 	return c.transaction, nil
 }
 
-func (c *Conn) Close() error {
-	// Implement connection close logic
-	if c.transaction != nil {
-		c.transaction.Rollback()
-		c.pool.Put(c.transaction.connection)
+// Convert Go argument types to a Litebase storage class, see convertValue for
+// the conversion rules.
+func (c *Conn) CheckNamedValue(value *driver.NamedValue) error {
+	converted, err := convertValue(value.Value, c.config.TimeEncoding)
+
+	if err != nil {
+		return err
 	}
 
+	value.Value = converted
+
 	return nil
 }
 
-func (c *Conn) ExecContext(ctx context.Context, sql string, args []driver.NamedValue) (driver.Result, error) {
-	// TODO: Support transaction id
-	connection, err := c.pool.Get()
-
-	if err != nil {
-		return nil, err
+func (c *Conn) Close() error {
+	// Roll back a transaction that was left open, this also releases the
+	// connection the transaction was pinned to.
+	if c.transaction != nil {
+		return c.transaction.Rollback()
 	}
 
-	defer c.pool.Put(connection)
+	return nil
+}
 
-	parameters, err := prepareParametersNamed(args)
+func (c *Conn) ExecContext(ctx context.Context, sql string, args []driver.NamedValue) (driver.Result, error) {
+	parameters, err := prepareParametersNamed(sql, args)
 
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := connection.Send(Query{
+	response, err := c.send(ctx, Query{
 		ID:         uuid.NewString(),
 		Statement:  sql,
 		Parameters: parameters,
@@ -80,23 +102,31 @@ func (c *Conn) ExecContext(ctx context.Context, sql string, args []driver.NamedV
 	}
 
 	if len(response.Error) > 0 {
-		return nil, errors.New(string(response.Error))
+		return nil, response.err()
 	}
 
-	return NewResult(
-		response.Data.Columns,
-		int64(response.Data.Changes),
-		int64(response.Data.LastInsertRowID),
-		response.Data.Rows,
-	), nil
+	return NewResultFromResponse(response), nil
+}
+
+func (c *Conn) QueryContext(ctx context.Context, sql string, args []driver.NamedValue) (driver.Rows, error) {
+	parameters, err := prepareParametersNamed(sql, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.query(ctx, Query{
+		ID:         uuid.NewString(),
+		Statement:  sql,
+		Parameters: parameters,
+	})
 }
 
 // Send a ping message to the database server and wait for a response
 func (c *Conn) Ping(ctx context.Context) error {
-	url, err := url.Parse(c.url)
+	url, err := url.Parse(c.config.URL)
 
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 
@@ -106,9 +136,15 @@ func (c *Conn) Ping(ctx context.Context) error {
 		host = fmt.Sprintf("%s:%s", host, url.Port())
 	}
 
+	accessKeyID, accessKeySecret, err := c.config.credentials(ctx)
+
+	if err != nil {
+		return err
+	}
+
 	token := SignRequest(
-		c.pool.accessKeyId,
-		c.pool.accessKeySecret,
+		accessKeyID,
+		accessKeySecret,
 		"POST",
 		"/query/stream",
 		map[string]string{
@@ -121,15 +157,9 @@ func (c *Conn) Ping(ctx context.Context) error {
 		map[string]string{},
 	)
 
-	httpClient := &http.Client{
-		Timeout: 0,
-	}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/query/stream", c.url), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/query/stream", c.config.URL), nil)
 
 	if err != nil {
-		log.Fatalln(err)
-
 		return err
 	}
 
@@ -137,10 +167,10 @@ func (c *Conn) Ping(ctx context.Context) error {
 	req.Header.Set("X-Litebase-Date", fmt.Sprintf("%d", time.Now().Unix()))
 	req.Header.Set("Authorization", fmt.Sprintf("Litebase-HMAC-SHA256 %s", token))
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.pool.httpClient.Do(req)
 
 	if err != nil {
-		return err
+		return badConn(err)
 	}
 
 	defer resp.Body.Close()
@@ -152,6 +182,179 @@ func (c *Conn) Ping(ctx context.Context) error {
 	return nil
 }
 
+// Report whether database/sql may keep using the connection. Queries run over
+// any stream in the pool, only an open transaction ties the connection to the
+// stream the transaction was started on.
+func (c *Conn) IsValid() bool {
+	if c.pool.isClosed() {
+		return false
+	}
+
+	return c.transaction == nil || c.transaction.valid()
+}
+
 func (c *Conn) Prepare(sql string) (driver.Stmt, error) {
-	return NewStatement(c.pool, sql), nil
+	return c.PrepareContext(context.Background(), sql)
+}
+
+func (c *Conn) PrepareContext(ctx context.Context, sql string) (driver.Stmt, error) {
+	return NewStatement(c, sql)
+}
+
+// Check the connection before database/sql reuses it, a connection that is
+// no longer valid is discarded.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+// Get the connection for a query: the one the active transaction is pinned
+// to, or any available connection from the pool. The returned function
+// releases the connection once the query is done with it.
+func (c *Conn) acquire(ctx context.Context, query *Query) (*Connection, func(), error) {
+	if c.transaction != nil {
+		query.TransactionID = c.transaction.id
+
+		return c.transaction.connection, func() {}, nil
+	}
+
+	connection, err := c.pool.Get(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return connection, func() { c.pool.Put(connection) }, nil
+}
+
+// Run a query that returns rows. The rows are streamed from the server, or
+// fetched through a cursor when a fetch size is set, and keep the connection
+// until they are closed.
+func (c *Conn) query(ctx context.Context, query Query) (driver.Rows, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	ctx, afterQuery := c.runHooks(ctx, query)
+
+	connection, releaseConnection, err := c.acquire(ctx, &query)
+
+	if err != nil {
+		afterQuery(err)
+		cancel()
+
+		return nil, err
+	}
+
+	// The timeout covers reading the rows, so it ends when they are closed.
+	release := func() {
+		cancel()
+		releaseConnection()
+	}
+
+	var stream *ResponseStream
+
+	if fetchSize := fetchSizeFromContext(ctx, c.config.FetchSize); fetchSize > 0 {
+		stream, err = connection.OpenCursor(ctx, query, fetchSize)
+	} else {
+		stream, err = connection.Stream(ctx, query)
+	}
+
+	if err != nil {
+		afterQuery(err)
+		release()
+
+		return nil, err
+	}
+
+	response, err := stream.Next()
+
+	if err == nil {
+		err = response.err()
+	}
+
+	afterQuery(err)
+
+	if err != nil {
+		stream.Close()
+		release()
+
+		return nil, err
+	}
+
+	return NewStreamingRows(response, stream, release), nil
+}
+
+// Send a query and wait for its whole response.
+func (c *Conn) send(ctx context.Context, query Query) (QueryResponse, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	ctx, afterQuery := c.runHooks(ctx, query)
+
+	connection, release, err := c.acquire(ctx, &query)
+
+	if err != nil {
+		afterQuery(err)
+		return QueryResponse{}, err
+	}
+
+	defer release()
+
+	response, err := connection.Send(ctx, query)
+
+	if err == nil {
+		afterQuery(response.err())
+	} else {
+		afterQuery(err)
+	}
+
+	return response, err
+}
+
+// Send a query over the given connection and wait for its whole response,
+// with the query timeout and hooks applied like for any other query.
+func (c *Conn) sendOver(ctx context.Context, connection *Connection, query Query) (QueryResponse, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	ctx, afterQuery := c.runHooks(ctx, query)
+
+	response, err := connection.Send(ctx, query)
+
+	if err == nil {
+		afterQuery(response.err())
+	} else {
+		afterQuery(err)
+	}
+
+	return response, err
+}
+
+// Call the BeforeQuery hook and return a function that calls the AfterQuery
+// hook with the error the query ended with.
+func (c *Conn) runHooks(ctx context.Context, query Query) (context.Context, func(error)) {
+	hooks := c.config.Hooks
+
+	if hooks.BeforeQuery != nil {
+		ctx = hooks.BeforeQuery(ctx, query)
+	}
+
+	start := time.Now()
+
+	return ctx, func(err error) {
+		if hooks.AfterQuery != nil {
+			hooks.AfterQuery(ctx, query, time.Since(start), err)
+		}
+	}
+}
+
+// Apply the query timeout to a context that has no
+// deadline of its own.
+func (c *Conn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.config.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, c.config.QueryTimeout)
 }
@@ -0,0 +1,74 @@
+package sql
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+// Create a Conn whose pool holds only the given test connection.
+func newTestConn(t *testing.T, c *Connection) *Conn {
+	t.Helper()
+
+	pool := &ConnectionPool{
+		activeConnections: 1,
+		config:            c.config,
+		connections:       []*ConnectionPoolItem{{connection: c}},
+		drained:           make(chan struct{}),
+		maxConnections:    1,
+		waiters:           list.New(),
+	}
+
+	return NewConn(c.config, pool)
+}
+
+func TestConnQueryFetchSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    int
+		ctx       context.Context
+		fetchSize int
+	}{
+		{"streamed", 0, context.Background(), 0},
+		{"cursor from the config", 10, context.Background(), 10},
+		{"cursor from the context", 0, WithFetchSize(context.Background(), 5), 5},
+		{"context overrides the config", 10, WithFetchSize(context.Background(), 5), 5},
+		{"context turns the cursor off", 10, WithFetchSize(context.Background(), 0), 0},
+	}
+
+	for _, test := range tests {
+		c := newTestConnection(t)
+		c.config.FetchSize = test.config
+
+		conn := newTestConn(t, c)
+		queried := make(chan error)
+
+		go func() {
+			rows, err := conn.QueryContext(test.ctx, "SELECT 1", nil)
+
+			if err == nil {
+				rows.Close()
+			}
+
+			queried <- err
+		}()
+
+		frame := nextTestFrame(t, c)
+
+		switch {
+		case test.fetchSize == 0 && frame.messageType != QueryStreamFrame:
+			t.Errorf("%s: expected the query to be streamed, got %v", test.name, frame.messageType)
+		case test.fetchSize > 0 && frame.messageType != QueryStreamOpenCursor:
+			t.Errorf("%s: expected a cursor, got %v", test.name, frame.messageType)
+		case test.fetchSize > 0 && binary.LittleEndian.Uint32(frame.payload) != uint32(test.fetchSize):
+			t.Errorf("%s: expected a fetch size of %d, got %d", test.name, test.fetchSize, binary.LittleEndian.Uint32(frame.payload))
+		}
+
+		c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte(frame.IDs()[0])}})
+
+		if err := <-queried; err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+	}
+}
@@ -8,8 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"sync"
@@ -18,70 +17,189 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// How long a cancelled query is remembered so that a response the server
+	// sent before it saw the cancellation can be dropped quietly.
+	cancelledQueryTTL = time.Minute
+
+	// The delay before the first reconnect attempt, it doubles with every
+	// attempt that fails up to reconnectMaxDelay.
+	reconnectBaseDelay = 100 * time.Millisecond
+	reconnectMaxDelay  = 10 * time.Second
+
+	// The largest message read from a stream. The server splits large results
+	// over several messages, a longer one is rejected instead of buffered.
+	maxMessageLength = 64 << 20
+)
+
 type Connection struct {
 	accessKeyID       string
 	accessKeySecret   string
 	buffers           *sync.Pool
 	cancel            context.CancelFunc
+	cancelledQueries  map[string]time.Time
 	closed            bool
-	connecting        bool
+	config            Config
 	connected         chan struct{}
 	ctx               context.Context
 	connectionError   error
 	date              string
+	drains            sync.WaitGroup
+	generation        int
+	httpClient        *http.Client
 	id                string
 	mutex             *sync.Mutex
+	onClosed          func()
 	previousSignature string
-	reader            io.ReadCloser
-	responses         map[string]chan QueryResponse
+	reader            *io.PipeReader
+	responses         map[string]*PendingQuery
+	statements        *StatementCache
+	stopped           chan struct{}
+	streaming         bool
 	writeQueue        *WriteQueue
 	writer            *bufio.Writer
-	url               string
 }
 
-func NewConnection(url, accessKeyId, accessKeySecret string) *Connection {
-	ctx, cancel := context.WithCancel(context.Background())
-	reader, writer := io.Pipe()
+// Returned for a stream the server asked to close, the connection moves on to
+// a new stream right away.
+var errStreamDraining = errors.New("stream is draining")
 
-	bufferedWriter := bufio.NewWriterSize(writer, 4096) // 4096 bytes buffer size
+// Returned for a prepared statement whose handle went away with the stream it
+// was prepared on.
+var errStatementLost = errors.New("prepared statement was lost when its stream failed")
+
+// The error of a handshake the server answered with an unexpected status.
+type handshakeError struct {
+	status     string
+	statusCode int
+}
+
+func (e *handshakeError) Error() string {
+	return fmt.Sprintf("request failed: %s", e.status)
+}
+
+// Report whether connecting again may succeed after an error. The server
+// rejecting the request, e.g. for invalid credentials, is not retried.
+func isRetryable(err error) bool {
+	var handshakeErr *handshakeError
+
+	if errors.As(err, &handshakeErr) {
+		return handshakeErr.statusCode >= 500 ||
+			handshakeErr.statusCode == http.StatusRequestTimeout ||
+			handshakeErr.statusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// Get the delay before a reconnect attempt. Half of the delay is random so
+// connections that failed together do not reconnect all at once.
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+
+	if attempt < 16 {
+		delay = min(reconnectBaseDelay<<(attempt-1), reconnectMaxDelay)
+	}
+
+	return delay/2 + rand.N(delay/2)
+}
+
+func NewConnection(config Config, httpClient *http.Client) *Connection {
+	ctx, cancel := context.WithCancel(context.Background())
 
 	c := &Connection{
-		accessKeyID:     accessKeyId,
-		accessKeySecret: accessKeySecret,
 		buffers: &sync.Pool{
 			New: func() interface{} {
 				return &bytes.Buffer{}
 			},
 		},
-		cancel:     cancel,
-		connected:  make(chan struct{}, 1),
-		connecting: false,
-		ctx:        ctx,
-		id:         uuid.NewString(),
-		mutex:      &sync.Mutex{},
-		reader:     reader,
-		responses:  map[string]chan QueryResponse{},
-		url:        url,
-		writer:     bufferedWriter,
+		cancel:           cancel,
+		cancelledQueries: map[string]time.Time{},
+		config:           config,
+		connected:        make(chan struct{}),
+		ctx:              ctx,
+		httpClient:       httpClient,
+		id:               uuid.NewString(),
+		mutex:            &sync.Mutex{},
+		responses:        map[string]*PendingQuery{},
+		statements:       NewStatementCache(DefaultStatementCacheSize),
+		stopped:          make(chan struct{}),
 	}
 
 	c.writeQueue = NewWriteQueue(c)
-	c.connecting = true
 
-	go func() {
+	go c.run()
+
+	return c
+}
+
+// Keep a stream to the server open, reconnecting with a growing delay between
+// attempts when it fails. The connection is closed once the attempts run out
+// or the server rejects the handshake.
+func (c *Connection) run() {
+	defer close(c.stopped)
+
+	// Streams the server is draining are read until they end.
+	defer c.drains.Wait()
+
+	attempts := 0
+
+	for {
 		err := c.connect()
 
-		if err != nil {
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		// The next stream is opened right away when the server drains one.
+		if err == errStreamDraining {
+			attempts = 0
+			continue
+		}
+
+		if c.streamFailed() {
+			attempts = 0
+		}
+
+		attempts++
+
+		if attempts > c.config.MaxReconnectAttempts || !isRetryable(err) {
+			c.mutex.Lock()
 			c.connectionError = err
+			c.mutex.Unlock()
+
 			c.Close()
+
+			break
 		}
-	}()
 
-	return c
+		c.config.Logger.Println("Reconnecting after stream error:", err)
+
+		select {
+		case <-time.After(reconnectDelay(attempts)):
+		case <-c.ctx.Done():
+		}
+	}
+
+	c.mutex.Lock()
+	onClosed := c.onClosed
+	c.mutex.Unlock()
+
+	if onClosed != nil {
+		onClosed()
+	}
 }
 
+// Open a stream to the server and read responses from it until it fails or
+// the connection is closed.
 func (c *Connection) connect() error {
-	connectionURL := fmt.Sprintf("%s/query/stream", c.url)
+	accessKeyID, accessKeySecret, err := c.config.credentials(c.ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	connectionURL := fmt.Sprintf("%s/query/stream", c.config.URL)
 
 	url, err := url.Parse(connectionURL)
 
@@ -96,18 +214,18 @@ func (c *Connection) connect() error {
 	}
 
 	// Store the date for chunk signing
-	c.date = fmt.Sprintf("%d", time.Now().Unix())
+	date := fmt.Sprintf("%d", time.Now().Unix())
 
 	token := SignRequest(
-		c.accessKeyID,
-		c.accessKeySecret,
+		accessKeyID,
+		accessKeySecret,
 		"POST",
 		url.Path,
 		map[string]string{
 			"Content-Length":  "0",
 			"Content-Type":    "application/octet-stream",
 			"Host":            host,
-			"X-Litebase-Date": c.date,
+			"X-Litebase-Date": date,
 		},
 		[]byte("STREAMING-LITEBASE-HMAC-SHA256-PAYLOAD"),
 		map[string]string{},
@@ -120,28 +238,47 @@ func (c *Connection) connect() error {
 		return err
 	}
 
-	c.previousSignature = seedSignature
+	// Every stream gets its own request body, closing it ends the request.
+	// The request gets its own context as well, so a request that is given
+	// up on does not keep going.
+	reader, writer := io.Pipe()
+	requestCtx, cancelRequest := context.WithCancel(c.ctx)
+	draining := false
 
-	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   3 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-	}
+	defer func() {
+		// A draining stream is closed once the server ended it.
+		if !draining {
+			reader.Close()
+			cancelRequest()
+		}
+	}()
 
-	httpClient := &http.Client{
-		Timeout:   0,
-		Transport: transport,
+	bufferedWriter := bufio.NewWriterSize(writer, 4096) // 4096 bytes buffer size
+
+	c.mutex.Lock()
+
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
 	}
 
-	req, err := http.NewRequest("POST", url.String(), c.reader)
+	c.accessKeyID = accessKeyID
+	c.accessKeySecret = accessKeySecret
+	c.date = date
+	c.previousSignature = seedSignature
+	c.reader = reader
+	c.writer = bufferedWriter
+
+	c.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(requestCtx, "POST", url.String(), reader)
 
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("X-Litebase-Date", c.date)
+	req.Header.Set("X-Litebase-Date", date)
 	req.Header.Set("Authorization", fmt.Sprintf("Litebase-HMAC-SHA256 %s", token))
 
 	respChan := make(chan *http.Response, 1)
@@ -149,7 +286,7 @@ func (c *Connection) connect() error {
 
 	// Start the HTTP request
 	go func() {
-		resp, err := httpClient.Do(req)
+		resp, err := c.httpClient.Do(req)
 
 		if err != nil {
 			httpErrChan <- err
@@ -158,19 +295,31 @@ func (c *Connection) connect() error {
 		respChan <- resp
 	}()
 
+	// Close the response of a request that was given up on, in case it
+	// arrives anyway.
+	abandon := func() {
+		go func() {
+			select {
+			case resp := <-respChan:
+				resp.Body.Close()
+			case <-httpErrChan:
+			}
+		}()
+	}
+
 	// Send connection message with timeout after request starts
 	connectionMsgChan := make(chan error, 1)
 	go func() {
 		// Give the HTTP request a moment to start
 		time.Sleep(10 * time.Millisecond)
 
-		_, err := c.writer.Write([]byte{byte(QueryStreamOpenConnection)})
+		_, err := bufferedWriter.Write([]byte{byte(QueryStreamOpenConnection)})
 		if err != nil {
 			connectionMsgChan <- err
 			return
 		}
 
-		err = c.writer.Flush()
+		err = bufferedWriter.Flush()
 		if err != nil {
 			connectionMsgChan <- err
 			return
@@ -183,10 +332,21 @@ func (c *Connection) connect() error {
 	select {
 	case err := <-connectionMsgChan:
 		if err != nil {
+			// The request body is closed when the request failed, report why.
+			select {
+			case err := <-httpErrChan:
+				return err
+			case <-time.After(c.config.ConnectTimeout):
+			}
+
+			abandon()
+
 			return fmt.Errorf("failed to send connection message: %w", err)
 		}
-	case <-time.After(3 * time.Second):
-		return fmt.Errorf("timeout sending connection message after 3 seconds")
+	case <-time.After(c.config.ConnectTimeout):
+		abandon()
+
+		return fmt.Errorf("timeout sending connection message after %s", c.config.ConnectTimeout)
 	}
 
 	// Wait for HTTP response
@@ -196,171 +356,658 @@ func (c *Connection) connect() error {
 		// HTTP request completed successfully
 	case err := <-httpErrChan:
 		return err
-	case <-time.After(5 * time.Second):
+	case <-time.After(c.config.ConnectTimeout):
+		abandon()
+
 		return fmt.Errorf("timeout waiting for HTTP response")
 	}
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("request failed: %s", resp.Status)
+		resp.Body.Close()
+		return &handshakeError{status: resp.Status, statusCode: resp.StatusCode}
 	}
 
-	defer resp.Body.Close()
-
-	responseChan := make(chan *bytes.Buffer, 1)
 	errChan := make(chan error, 1)
+	drainChan := make(chan struct{})
 
 	// Read responses in a separate goroutine
 	go func() {
-		defer close(responseChan)
-		defer close(errChan)
+		errChan <- c.readResponses(resp.Body, drainChan)
+	}()
 
-		messageHeaderBytes := make([]byte, 5)
-		scanBuffer := bytes.NewBuffer(make([]byte, 1024))
+	select {
+	case <-c.ctx.Done():
+		resp.Body.Close()
+		return nil
+	case err := <-errChan:
+		resp.Body.Close()
+		c.config.Logger.Println("Error reading response:", err)
+		return err
+	case <-drainChan:
+	}
 
-		for {
-			select {
-			case <-c.ctx.Done():
-				return
-			default:
-				scanBuffer.Reset()
+	// The server is draining the stream. The queries written to it still get
+	// their responses while the next stream takes the queued ones.
+	draining = true
+	generation := c.streamDraining()
+	writer.Close()
 
-				_, err := resp.Body.Read(messageHeaderBytes)
+	c.drains.Add(1)
 
-				if err != nil {
-					errChan <- err
-					return
-				}
+	go func() {
+		defer c.drains.Done()
 
-				messageType := messageHeaderBytes[0]
-				messageLength := int(binary.LittleEndian.Uint32(messageHeaderBytes[1:]))
+		select {
+		case <-errChan:
+		case <-c.ctx.Done():
+		}
 
-				bytesRead := 0
+		resp.Body.Close()
+		reader.Close()
+		cancelRequest()
+		c.streamDrained(generation)
+	}()
 
-				for bytesRead < messageLength {
-					chunkSize := min(messageLength-bytesRead, 1024)
+	return errStreamDraining
+}
 
-					n, err := io.CopyN(scanBuffer, resp.Body, int64(chunkSize))
+// Read the responses of a stream and hand them to the queries waiting for
+// them, until reading fails. The drain channel is closed when the server asks
+// to move to another stream.
+func (c *Connection) readResponses(body io.Reader, drain chan struct{}) error {
+	messageHeaderBytes := make([]byte, 5)
+	scanBuffer := bytes.NewBuffer(make([]byte, 1024))
 
-					if err != nil {
-						log.Println(err)
-						break
-					}
+	for {
+		scanBuffer.Reset()
 
-					bytesRead += int(n)
-				}
+		_, err := io.ReadFull(body, messageHeaderBytes)
 
-				switch QueryStreamMessageType(messageType) {
-				case QueryStreamOpenConnection:
-					c.connected <- struct{}{}
-					c.connecting = false
-				case QueryStreamError:
-					errChan <- errors.New(string(scanBuffer.Bytes()[0:messageLength]))
-				case QueryStreamFrame:
-					responseBuffer := c.buffers.Get().(*bytes.Buffer)
-					responseBuffer.Reset()
-					responseBuffer.Write(scanBuffer.Bytes()[0:messageLength])
-					responseChan <- responseBuffer
-				}
+		if err == nil {
+			messageLength := int64(binary.LittleEndian.Uint32(messageHeaderBytes[1:]))
+
+			if messageLength > maxMessageLength {
+				return fmt.Errorf("%w: message of %d bytes exceeds the limit of %d bytes", ErrMalformedResponse, messageLength, maxMessageLength)
 			}
+
+			_, err = io.CopyN(scanBuffer, body, messageLength)
 		}
-	}()
 
-	// Read responses
-	for {
-		select {
-		case <-c.ctx.Done():
-			return nil
-		case err := <-errChan:
-			log.Println("Error reading response:", err)
-			c.Close()
+		if err != nil {
 			return err
-		case responseBuffer := <-responseChan:
-			for responseBuffer.Len() > 0 {
-				queryResponses := QueryResponseDecoder(responseBuffer)
-				data := queryResponses[0].Data
-				id := data.ID
-
-				c.mutex.Lock()
-				responseChannel, ok := c.responses[string(id)]
-				c.mutex.Unlock()
-
-				if ok {
-					responseChannel <- queryResponses[0]
-				} else {
-					c.buffers.Put(responseBuffer)
-					log.Println("No response channel for id:", string(id))
-					continue
+		}
+
+		switch QueryStreamMessageType(messageHeaderBytes[0]) {
+		case QueryStreamOpenConnection:
+			c.mutex.Lock()
+
+			if !c.streaming {
+				c.streaming = true
+				close(c.connected)
+			}
+
+			c.mutex.Unlock()
+
+			// Write the queries that were queued while connecting.
+			c.writeQueue.notify()
+		case QueryStreamCloseConnection:
+			select {
+			case <-drain:
+			default:
+				close(drain)
+			}
+		case QueryStreamError:
+			// An error of a single query is delivered to that query, any
+			// other error, e.g. failing authentication, ends the stream.
+			response, err := decodeErrorEntry(bytes.Clone(scanBuffer.Bytes()))
+
+			if err != nil || len(response.Data.ID) == 0 {
+				return errors.New(scanBuffer.String())
+			}
+
+			c.deliver(response)
+		case QueryStreamFrame:
+			for scanBuffer.Len() > 0 {
+				// The rest of the stream cannot be trusted after a malformed
+				// frame.
+				queryResponses, err := QueryResponseDecoder(scanBuffer)
+
+				if err != nil {
+					return err
 				}
+
+				c.deliver(queryResponses[0])
 			}
+		}
+	}
+}
+
+// Hand a response to the query waiting for it.
+func (c *Connection) deliver(response QueryResponse) {
+	id := string(response.Data.ID)
+
+	c.mutex.Lock()
+	pendingQuery, ok := c.responses[id]
+	// A cancelled query may still receive several responses that were sent
+	// before the server saw the cancellation.
+	_, cancelled := c.cancelledQueries[id]
+	c.mutex.Unlock()
+
+	if ok {
+		if !pendingQuery.deliver(response) {
+			c.dropSlowQuery(id, pendingQuery)
+		}
+	} else if !cancelled {
+		c.config.Logger.Println("No response channel for id:", id)
+	}
+}
+
+// Fail and cancel a query whose reader fell too far behind, instead of
+// holding its responses in memory or holding up the rest of the stream.
+func (c *Connection) dropSlowQuery(id string, pendingQuery *PendingQuery) {
+	c.mutex.Lock()
+
+	if c.responses[id] != pendingQuery {
+		c.mutex.Unlock()
+		return
+	}
+
+	delete(c.responses, id)
+	pendingQuery.fail(ErrReaderTooSlow)
+
+	c.mutex.Unlock()
+
+	c.cancelQuery(id)
+}
 
-			c.buffers.Put(responseBuffer)
+// Clean up after the stream failed, so the next stream starts fresh. Queries
+// that may have reached the server fail with ErrConnectionLost, the ones that
+// were never written stay queued for the next stream. Returns whether the
+// stream had been established.
+func (c *Connection) streamFailed() bool {
+	return c.endStream(true)
+}
+
+// Stop writing to a stream the server is draining and start the next one.
+// The queries written to the stream are left waiting for their responses,
+// while transactions open on the stream cannot run any more statements.
+// Returns the generation of the draining stream.
+func (c *Connection) streamDraining() int {
+	c.mutex.Lock()
+	generation := c.generation
+	c.mutex.Unlock()
+
+	c.endStream(false)
+
+	return generation
+}
+
+// Fail the queries still waiting for responses from a drained stream once
+// the server closed it.
+func (c *Connection) streamDrained(generation int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, pendingQuery := range c.responses {
+		if pendingQuery.written && pendingQuery.generation == generation {
+			delete(c.responses, id)
+			c.cancelledQueries[id] = time.Now()
+			pendingQuery.fail(ErrConnectionLost)
 		}
 	}
 }
 
+// Move on from the current stream. When the stream failed, the queries
+// written to it fail as well. The unwritten queries that refer to a statement
+// handle or transaction of the stream cannot run on the next one, the others
+// stay queued for it. Returns whether the stream had been established.
+func (c *Connection) endStream(failed bool) bool {
+	c.writeQueue.mutex.Lock()
+	defer c.writeQueue.mutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	established := c.streaming
+
+	for id, pendingQuery := range c.responses {
+		if pendingQuery.written {
+			if failed && pendingQuery.generation == c.generation {
+				delete(c.responses, id)
+				c.cancelledQueries[id] = time.Now()
+				pendingQuery.fail(ErrConnectionLost)
+			}
+		} else if !pendingQuery.replayable {
+			// The query never reached the server, so it is safe to retry
+			// on another connection.
+			delete(c.responses, id)
+			c.cancelledQueries[id] = time.Now()
+			pendingQuery.fail(badConn(ErrConnectionLost))
+		}
+	}
+
+	if established {
+		c.streaming = false
+		c.connected = make(chan struct{})
+		c.generation++
+	}
+
+	// Prepared statements do not outlive the stream they were prepared on.
+	c.statements.Invalidate()
+
+	c.writeQueue.retain(func(id string) bool {
+		_, ok := c.responses[id]
+		return ok
+	})
+
+	return established
+}
+
 func (c *Connection) Close() error {
+	c.mutex.Lock()
+
 	if c.closed {
+		c.mutex.Unlock()
 		return nil
 	}
 
 	c.closed = true
+	reader := c.reader
+
+	// Queries that never reached the server can run on another connection.
+	err := c.closedError()
+
+	for id, pendingQuery := range c.responses {
+		delete(c.responses, id)
+		c.cancelledQueries[id] = time.Now()
+
+		if pendingQuery.written {
+			pendingQuery.fail(ErrConnectionLost)
+		} else {
+			pendingQuery.fail(badConn(err))
+		}
+	}
+
+	c.mutex.Unlock()
+
 	c.cancel()
 	c.writeQueue.Close()
-	c.writer.Flush()
-	c.reader.Close()
+
+	if reader != nil {
+		reader.Close()
+	}
 
 	return nil
 }
 
-func (c *Connection) Send(query Query) (QueryResponse, error) {
+// Get the reason the connection was closed. The caller must hold the mutex.
+func (c *Connection) closedError() error {
+	if c.connectionError != nil {
+		return c.connectionError
+	}
+
+	return errors.New("connection is closed")
+}
+
+// Tell the server the stream is closing once the frames queued before were
+// written, then close the connection and wait for its goroutines to stop or
+// the context to end.
+func (c *Connection) shutdown(ctx context.Context) {
 	c.mutex.Lock()
+	streaming := c.streaming && !c.closed
+	c.mutex.Unlock()
 
-	if c.connecting {
-		select {
-		case <-c.ctx.Done():
-			if c.connectionError != nil {
-				return QueryResponse{}, c.connectionError
-			}
-		case <-c.connected:
+	if streaming && ctx.Err() == nil {
+		err := c.writeQueue.writeMessageAndWait(ctx, QueryStreamCloseConnection, nil)
+
+		if err != nil {
+			c.config.Logger.Println("Error closing stream:", err)
 		}
 	}
 
-	c.mutex.Unlock()
+	c.Close()
 
-	if c.closed {
-		return QueryResponse{}, fmt.Errorf("connection is closed")
+	select {
+	case <-c.stopped:
+	case <-ctx.Done():
 	}
+}
+
+// Get the number of times the stream was replaced after failing.
+func (c *Connection) streamGeneration() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.generation
+}
+
+// Report whether the connection was closed for good.
+func (c *Connection) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+// Send a query over the stream and wait for its response. The wait ends early
+// when the context is cancelled or its deadline passes, or when the
+// connection is closed.
+//
+// Queries that set Prepare run through a prepared statement handle, the
+// statement is prepared on the server the first time it is sent over this
+// connection.
+func (c *Connection) Send(ctx context.Context, query Query) (QueryResponse, error) {
+	stream, err := c.Stream(ctx, query)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	defer stream.Close()
 
+	return stream.Collect()
+}
+
+// Send a query over the stream and return a stream of its responses as they
+// arrive, so rows are read while the server is still sending them. The stream
+// must be closed once the caller is done reading.
+func (c *Connection) Stream(ctx context.Context, query Query) (*ResponseStream, error) {
+	return c.stream(ctx, query, 0)
+}
+
+// Open a server-side cursor for a query. The server sends the first fetchSize
+// rows right away and every following batch of rows only once the stream
+// asks for it, so neither side buffers more than one batch. The cursor is
+// closed when the stream is closed.
+func (c *Connection) OpenCursor(ctx context.Context, query Query, fetchSize int) (*ResponseStream, error) {
+	if fetchSize <= 0 {
+		return nil, fmt.Errorf("invalid fetch size: %d", fetchSize)
+	}
+
+	return c.stream(ctx, query, fetchSize)
+}
+
+// Send a query and return a stream of its responses, through a cursor when
+// the fetch size is set.
+func (c *Connection) stream(ctx context.Context, query Query, fetchSize int) (*ResponseStream, error) {
 	if query.ID == "" {
-		return QueryResponse{}, fmt.Errorf("message must have an id")
+		return nil, fmt.Errorf("message must have an id")
+	}
+
+	var statement *CachedStatement
+
+	if query.Prepare && query.StatementHandle == "" {
+		var err error
+
+		statement, err = c.prepare(ctx, query.Statement)
+
+		if err != nil {
+			return nil, err
+		}
+
+		query.StatementHandle = statement.handle
+	}
+
+	bufferSize := ResponseStreamBufferSize
+
+	if fetchSize > 0 {
+		// A cursor only has one batch of rows in flight at a time.
+		bufferSize = 1
+	}
+
+	// The statement handle and transaction of a query belong to the stream it
+	// was meant for.
+	replayable := query.StatementHandle == "" && query.TransactionID == ""
+
+	stream, err := c.openStream(ctx, query.ID, bufferSize, replayable, statement, func() error {
+		outputBuffer := c.buffers.Get().(*bytes.Buffer)
+		defer c.buffers.Put(outputBuffer)
+
+		parametersBuffer := c.buffers.Get().(*bytes.Buffer)
+		defer c.buffers.Put(parametersBuffer)
+
+		queryRequest, err := QueryRequestEncoder(query, outputBuffer, parametersBuffer)
+
+		if err != nil {
+			return err
+		}
+
+		if fetchSize > 0 {
+			payload := binary.LittleEndian.AppendUint32(nil, uint32(fetchSize))
+			payload = append(payload, queryRequest...)
+
+			c.writeQueue.WriteMessage(QueryStreamOpenCursor, query.ID, payload)
+
+			return nil
+		}
+
+		// The request is copied out of the pooled buffer since the frame may
+		// still be queued when Send returns early on cancellation.
+		c.writeQueue.Write(query.ID, bytes.Clone(queryRequest))
+
+		return nil
+	})
+
+	if err == nil {
+		stream.fetchSize = fetchSize
+	}
+
+	if statement != nil {
+		if err != nil {
+			c.releaseStatement(statement)
+		} else {
+			stream.onClose = func() { c.releaseStatement(statement) }
+		}
+	}
+
+	return stream, err
+}
+
+// Write a request with the given write function and wait for the response
+// with the same id.
+func (c *Connection) roundTrip(ctx context.Context, id string, write func() error) (QueryResponse, error) {
+	stream, err := c.openStream(ctx, id, 1, true, nil, write)
+
+	if err != nil {
+		return QueryResponse{}, err
 	}
 
-	responseChannel := make(chan QueryResponse, 1)
+	defer stream.Close()
 
+	return stream.Collect()
+}
+
+// Register a stream for the responses with the id, then write the request
+// with the given write function. Up to bufferSize responses are buffered
+// for the stream, the ones after them are queued until it catches up.
+//
+// A query is replayable when it can be sent again over the next stream if the
+// stream fails before the query was written. A query that uses a prepared
+// statement passes it, so it is not sent over a stream the statement does not
+// exist on.
+func (c *Connection) openStream(ctx context.Context, id string, bufferSize int, replayable bool, statement *CachedStatement, write func() error) (*ResponseStream, error) {
 	c.mutex.Lock()
-	c.responses[query.ID] = responseChannel
+	connected := c.connected
 	c.mutex.Unlock()
 
-	defer func() {
-		c.mutex.Lock()
-		delete(c.responses, query.ID)
+	select {
+	case <-connected:
+	case <-c.ctx.Done():
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	stream := NewResponseStream(ctx, c, id, NewPendingQuery(bufferSize))
+	stream.pendingQuery.replayable = replayable
+
+	c.mutex.Lock()
+
+	if c.closed {
+		err := c.closedError()
 		c.mutex.Unlock()
-	}()
 
-	outputBuffer := c.buffers.Get().(*bytes.Buffer)
-	defer c.buffers.Put(outputBuffer)
+		// Nothing was written, the query can run on another connection.
+		return nil, badConn(err)
+	}
 
-	parametersBuffer := c.buffers.Get().(*bytes.Buffer)
-	defer c.buffers.Put(parametersBuffer)
+	// The stream the statement was prepared on failed since, which also
+	// invalidated the cache while holding the mutex.
+	if statement != nil && !c.statements.valid(statement) {
+		c.mutex.Unlock()
 
-	queryRequest := QueryRequestEncoder(query, outputBuffer, parametersBuffer)
+		return nil, badConn(errStatementLost)
+	}
 
-	c.writeQueue.Write(queryRequest)
+	c.responses[id] = stream.pendingQuery
 
-	select {
-	case response := <-responseChannel:
-		return response, nil
-	case <-time.After(3 * time.Second):
-		return QueryResponse{}, fmt.Errorf("timeout waiting for response %s", query.ID)
+	c.mutex.Unlock()
+
+	if err := write(); err != nil {
+		c.removePendingQuery(id)
+		stream.pendingQuery.Close()
+
+		return nil, err
 	}
+
+	return stream, nil
+}
+
+// Stop waiting for the responses of a query. A query that is still queued is
+// dropped from the write queue so it never reaches the server. Reports
+// whether the query was dropped.
+func (c *Connection) removePendingQuery(id string) bool {
+	c.writeQueue.mutex.Lock()
+	defer c.writeQueue.mutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pendingQuery, ok := c.responses[id]
+
+	if !ok {
+		return false
+	}
+
+	delete(c.responses, id)
+
+	if pendingQuery.written {
+		return false
+	}
+
+	c.writeQueue.retain(func(queued string) bool {
+		return queued != id
+	})
+
+	return true
+}
+
+// Get the prepared statement for the SQL from the cache, or prepare it on the
+// server. The statement must be released once the query using it is done.
+func (c *Connection) prepare(ctx context.Context, sql string) (*CachedStatement, error) {
+	if statement, ok := c.statements.Acquire(sql); ok {
+		return statement, nil
+	}
+
+	id := uuid.NewString()
+
+	// The stream may fail while the statement is prepared, taking the
+	// handle with it.
+	epoch := c.statements.Epoch()
+
+	response, err := c.roundTrip(ctx, id, func() error {
+		payload := binary.LittleEndian.AppendUint32(nil, uint32(len(id)))
+		payload = append(payload, id...)
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(sql)))
+		payload = append(payload, sql...)
+
+		c.writeQueue.WriteMessage(QueryStreamPrepareStatement, id, payload)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Error) > 0 {
+		return nil, response.err()
+	}
+
+	if len(response.Data.StatementHandle) == 0 {
+		return nil, errors.New("server did not return a statement handle")
+	}
+
+	statement, closable, ok := c.statements.Add(sql, string(response.Data.StatementHandle), epoch)
+
+	if !ok {
+		return nil, badConn(errStatementLost)
+	}
+
+	for _, handle := range closable {
+		c.closeStatement(handle)
+	}
+
+	return statement, nil
+}
+
+func (c *Connection) releaseStatement(statement *CachedStatement) {
+	if c.statements.Release(statement) {
+		c.closeStatement(statement.handle)
+	}
+}
+
+// Tell the server a prepared statement handle is no longer used.
+func (c *Connection) closeStatement(handle string) {
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(handle)))
+	payload = append(payload, handle...)
+
+	c.writeQueue.WriteMessage(QueryStreamCloseStatement, "", payload)
+}
+
+// Tell the server to stop working on a query the caller is no longer waiting
+// for. The id is remembered for a while so a late response is dropped quietly.
+func (c *Connection) cancelQuery(id string) {
+	c.ignoreResponses(id)
+
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(id)))
+	payload = append(payload, id...)
+
+	c.writeQueue.WriteMessage(QueryStreamCancelQuery, "", payload)
+}
+
+// Ask the server for the next batch of rows of a cursor.
+func (c *Connection) fetchCursor(id string, fetchSize int) {
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(id)))
+	payload = append(payload, id...)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(fetchSize))
+
+	c.writeQueue.WriteMessage(QueryStreamFetchCursor, "", payload)
+}
+
+// Close a cursor on the server before all of its rows were fetched.
+func (c *Connection) closeCursor(id string) {
+	c.ignoreResponses(id)
+
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(id)))
+	payload = append(payload, id...)
+
+	c.writeQueue.WriteMessage(QueryStreamCloseCursor, "", payload)
+}
+
+// Remember a query the caller stopped waiting for, so the responses the
+// server still sends for it are dropped quietly.
+func (c *Connection) ignoreResponses(id string) {
+	now := time.Now()
+
+	c.mutex.Lock()
+
+	for cancelledID, cancelledAt := range c.cancelledQueries {
+		if now.Sub(cancelledAt) > cancelledQueryTTL {
+			delete(c.cancelledQueries, cancelledID)
+		}
+	}
+
+	c.cancelledQueries[id] = now
+
+	c.mutex.Unlock()
 }
@@ -1,109 +1,213 @@
 package sql
 
 import (
-	"errors"
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
 	"sync"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
 type ConnectionPool struct {
-	accessKeyId       string
-	accessKeySecret   string
 	activeConnections int
+	closed            bool
+	config            Config
 	connections       []*ConnectionPoolItem
+	drained           chan struct{}
+	httpClient        *http.Client
 	maxConnections    int
 	mutex             sync.Mutex
-	url               string
+	ownsTransport     bool
+	waitCount         int64
+	waitDuration      time.Duration
+	waiters           *list.List
 }
 
 type ConnectionPoolItem struct {
 	connection *Connection
-	semaphore  *semaphore.Weighted
+	inFlight   int
+}
+
+// PoolStats are the statistics of a connection pool.
+type PoolStats struct {
+	// The most connections the pool opens.
+	MaxConnections int
+	// The number of open connections.
+	OpenConnections int
+	// The number of queries running over the open connections.
+	InFlight int
+	// The number of callers waiting for a connection.
+	Waiting int
+	// The total number of callers that waited for a connection.
+	WaitCount int64
+	// The total time callers waited for a connection.
+	WaitDuration time.Duration
 }
 
-func NewConnectionPool(
-	accessKeyID, accessKeySecret, url string,
-	maxConnections int,
-) *ConnectionPool {
+func NewConnectionPool(config Config) *ConnectionPool {
+	transport := config.Transport
+
+	if transport == nil {
+		transport = &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   3 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig: config.TLSConfig,
+		}
+	}
+
 	pool := &ConnectionPool{
-		accessKeyId:       accessKeyID,
-		accessKeySecret:   accessKeySecret,
 		activeConnections: 0,
+		config:            config,
 		connections:       []*ConnectionPoolItem{},
-		maxConnections:    maxConnections,
-		mutex:             sync.Mutex{},
-		url:               url,
+		drained:           make(chan struct{}),
+		httpClient: &http.Client{
+			Timeout:   0,
+			Transport: transport,
+		},
+		maxConnections: config.MaxConnections,
+		mutex:          sync.Mutex{},
+		ownsTransport:  config.Transport == nil,
+		waiters:        list.New(),
+	}
+
+	// Open the minimum number of connections up front so the first queries
+	// do not wait for the handshake.
+	for i := 0; i < config.MinConnections; i++ {
+		pool.open()
 	}
 
 	return pool
 }
 
-func (p *ConnectionPool) Close() {
+// Close the pool. New callers get ErrConnectorClosed right away, the queries
+// already running get Config.CloseTimeout to finish before the server is told
+// the streams are closing and the connections are closed. An error is returned
+// when queries were still running at the timeout.
+func (p *ConnectionPool) Close() error {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
 
-	for _, item := range p.connections {
-		item.connection.Close()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
 	}
 
-	p.connections = []*ConnectionPoolItem{}
-	p.activeConnections = 0
-}
+	p.closed = true
+
+	// Wake the waiting callers up without a connection.
+	for p.waiters.Len() > 0 {
+		waiter := p.waiters.Remove(p.waiters.Front()).(chan *ConnectionPoolItem)
+		waiter <- nil
+	}
 
-// Find an available connection from the pool or create a new one if the pool
-// has an empty slot. If the pool is full, the function will block until a
-// connection is available.
-func (p *ConnectionPool) Get() (*Connection, error) {
-	tries := 0
+	// Tells the pool right away when no query is running.
+	p.handOff()
+	p.mutex.Unlock()
 
-	for {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.CloseTimeout)
+	defer cancel()
+
+	var err error
+
+	select {
+	case <-p.drained:
+	case <-ctx.Done():
 		p.mutex.Lock()
+		err = fmt.Errorf("closed with %d queries still running: %w", p.inFlight(), ctx.Err())
+		p.mutex.Unlock()
+	}
 
-		if tries > 10 {
-			break
-		}
+	p.mutex.Lock()
+	items := p.connections
+	p.connections = []*ConnectionPoolItem{}
+	p.activeConnections = 0
+	p.mutex.Unlock()
 
-		for _, item := range p.connections {
-			if item.semaphore.TryAcquire(1) {
-				p.mutex.Unlock()
-				return item.connection, nil
-			}
-		}
+	// The mutex is not held here, closed connections remove themselves from
+	// the pool.
+	for _, item := range items {
+		item.connection.shutdown(ctx)
+	}
 
-		if p.activeConnections < p.maxConnections {
-			connection := NewConnection(p.url, p.accessKeyId, p.accessKeySecret)
+	if p.ownsTransport {
+		p.httpClient.CloseIdleConnections()
+	}
 
-			p.activeConnections++
+	return err
+}
 
-			item := &ConnectionPoolItem{
-				connection: connection,
-				semaphore:  semaphore.NewWeighted(50),
-			}
+// Find a connection that can take another query, or create a new one if the
+// pool has an empty slot. If the pool is full, the caller waits in line until
+// a query finishes or the context ends. Callers are served in the order they
+// started waiting.
+func (p *ConnectionPool) Get(ctx context.Context) (*Connection, error) {
+	p.mutex.Lock()
 
-			item.semaphore.TryAcquire(1)
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, ErrConnectorClosed
+	}
 
-			p.connections = append(p.connections, item)
+	// Only take a free slot directly when nobody is waiting for one, so new
+	// callers do not jump the queue.
+	if p.waiters.Len() == 0 {
+		if item := p.available(); item != nil {
+			item.inFlight++
 			p.mutex.Unlock()
 
-			return connection, nil
+			return item.connection, nil
 		}
-
-		tries++
-
-		time.Sleep(1 * time.Millisecond)
 	}
 
+	waiter := make(chan *ConnectionPoolItem, 1)
+	element := p.waiters.PushBack(waiter)
+	p.waitCount++
 	p.mutex.Unlock()
 
-	return nil, errors.New("no available connections")
+	start := time.Now()
+
+	select {
+	case item := <-waiter:
+		p.mutex.Lock()
+		p.waitDuration += time.Since(start)
+		p.mutex.Unlock()
+
+		// The pool was closed while waiting.
+		if item == nil {
+			return nil, ErrConnectorClosed
+		}
+
+		return item.connection, nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.waitDuration += time.Since(start)
+
+		// A slot may have been handed over right as the context ended.
+		select {
+		case item := <-waiter:
+			if item != nil {
+				p.release(item)
+			}
+		default:
+			p.waiters.Remove(element)
+		}
+
+		return nil, ctx.Err()
+	}
 }
 
-func (p *ConnectionPool) Put(conn *Connection) {
+func (p *ConnectionPool) Put(connection *Connection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	for _, item := range p.connections {
-		if item.connection.id == conn.id {
-			item.semaphore.Release(1)
+		if item.connection.id == connection.id {
+			p.release(item)
 			return
 		}
 	}
@@ -118,7 +222,124 @@ func (p *ConnectionPool) Remove(connection *Connection) {
 			p.connections = append(p.connections[:i], p.connections[i+1:]...)
 			p.activeConnections--
 			connection.Close()
+
+			// The empty slot can be filled with a new connection.
+			p.handOff()
+
 			return
 		}
 	}
 }
+
+// Get the current statistics of the pool.
+func (p *ConnectionPool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := PoolStats{
+		MaxConnections:  p.maxConnections,
+		OpenConnections: p.activeConnections,
+		Waiting:         p.waiters.Len(),
+		WaitCount:       p.waitCount,
+		WaitDuration:    p.waitDuration,
+	}
+
+	stats.InFlight = p.inFlight()
+
+	return stats
+}
+
+// Report whether the pool was closed.
+func (p *ConnectionPool) isClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.closed
+}
+
+// Find a connection with room for another query, opening a new one when all
+// of them are busy and the pool is not full. The caller must hold the mutex.
+func (p *ConnectionPool) available() *ConnectionPoolItem {
+	for _, item := range p.connections {
+		if item.inFlight < p.config.MaxInFlight && !item.connection.isClosed() {
+			return item
+		}
+	}
+
+	if p.activeConnections < p.maxConnections {
+		return p.open()
+	}
+
+	return nil
+}
+
+// Hand free slots to the callers waiting the longest. Once the pool is closed
+// nobody waits, instead the pool is told when the last query finished. The
+// caller must hold the mutex.
+func (p *ConnectionPool) handOff() {
+	if p.closed {
+		if p.inFlight() == 0 {
+			select {
+			case <-p.drained:
+			default:
+				close(p.drained)
+			}
+		}
+
+		return
+	}
+
+	for p.waiters.Len() > 0 {
+		item := p.available()
+
+		if item == nil {
+			return
+		}
+
+		item.inFlight++
+
+		waiter := p.waiters.Remove(p.waiters.Front()).(chan *ConnectionPoolItem)
+		waiter <- item
+	}
+}
+
+// Open a new connection and add it to the pool. The caller must hold the
+// mutex once the pool is in use.
+func (p *ConnectionPool) open() *ConnectionPoolItem {
+	item := &ConnectionPoolItem{
+		connection: NewConnection(p.config, p.httpClient),
+	}
+
+	// A connection that gave up reconnecting leaves the pool so its slot can
+	// be filled with a new one.
+	item.connection.mutex.Lock()
+	item.connection.onClosed = func() {
+		p.Remove(item.connection)
+	}
+	item.connection.mutex.Unlock()
+
+	p.activeConnections++
+	p.connections = append(p.connections, item)
+
+	return item
+}
+
+// Free the slot of a finished query and pass it on to a waiting caller. The
+// caller must hold the mutex.
+func (p *ConnectionPool) release(item *ConnectionPoolItem) {
+	item.inFlight--
+
+	p.handOff()
+}
+
+// Get the number of queries running over the connections of the pool. The
+// caller must hold the mutex.
+func (p *ConnectionPool) inFlight() int {
+	inFlight := 0
+
+	for _, item := range p.connections {
+		inFlight += item.inFlight
+	}
+
+	return inFlight
+}
@@ -0,0 +1,138 @@
+package sql
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestConnectionPoolGet(t *testing.T) {
+	config, err := Config{
+		URL:             "http://127.0.0.1:1",
+		AccessKeyID:     "test",
+		AccessKeySecret: "test",
+		MaxConnections:  1,
+		MaxInFlight:     1,
+		Logger:          log.New(io.Discard, "", 0),
+	}.withDefaults()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewConnectionPool(config)
+	defer pool.Close()
+
+	connection, err := pool.Get(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting ends with the context when the pool is full
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := pool.Get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// Waiting callers are served in order
+	served := make(chan int, 3)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			connection, err := pool.Get(context.Background())
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			served <- i
+			pool.Put(connection)
+		}()
+
+		for pool.Stats().Waiting != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	pool.Put(connection)
+
+	for i := 0; i < 3; i++ {
+		if n := <-served; n != i {
+			t.Fatalf("expected caller %d to be served, got %d", i, n)
+		}
+	}
+
+	stats := pool.Stats()
+
+	if stats.Waiting != 0 || stats.WaitCount != 4 || stats.WaitDuration == 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestConnectionPoolClose(t *testing.T) {
+	config, err := Config{
+		URL:             "http://127.0.0.1:1",
+		AccessKeyID:     "test",
+		AccessKeySecret: "test",
+		MaxConnections:  1,
+		MaxInFlight:     1,
+		Logger:          log.New(io.Discard, "", 0),
+	}.withDefaults()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewConnectionPool(config)
+
+	connection, err := pool.Get(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan error)
+
+	go func() {
+		_, err := pool.Get(context.Background())
+		waited <- err
+	}()
+
+	for pool.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error)
+
+	go func() {
+		closed <- pool.Close()
+	}()
+
+	// Waiting callers are turned away
+	if err := <-waited; err != ErrConnectorClosed {
+		t.Fatalf("expected %v, got %v", ErrConnectorClosed, err)
+	}
+
+	if _, err := pool.Get(context.Background()); err != ErrConnectorClosed {
+		t.Fatalf("expected %v, got %v", ErrConnectorClosed, err)
+	}
+
+	// Closing waits for the running query
+	select {
+	case err := <-closed:
+		t.Fatalf("closed before the query finished: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	pool.Put(connection)
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,576 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Create a connection over an established stream. The write queue is not
+// started, so written frames stay queued.
+func newTestConnection(t *testing.T) *Connection {
+	t.Helper()
+
+	config, err := Config{
+		URL:             "http://127.0.0.1:1",
+		AccessKeyID:     "test",
+		AccessKeySecret: "test",
+		Logger:          log.New(io.Discard, "", 0),
+	}.withDefaults()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Connection{
+		buffers:          &sync.Pool{New: func() any { return &bytes.Buffer{} }},
+		cancel:           func() {},
+		cancelledQueries: map[string]time.Time{},
+		config:           config,
+		connected:        make(chan struct{}),
+		ctx:              context.Background(),
+		mutex:            &sync.Mutex{},
+		responses:        map[string]*PendingQuery{},
+		statements:       NewStatementCache(DefaultStatementCacheSize),
+		streaming:        true,
+	}
+
+	c.writeQueue = &WriteQueue{cancel: func() {}, connection: c, mutex: &sync.Mutex{}, wake: make(chan struct{}, 1)}
+
+	close(c.connected)
+
+	return c
+}
+
+// Take the next frame queued on a test connection like the write queue
+// would, waiting for one when there is none yet.
+func nextTestFrame(t *testing.T, c *Connection) *Frame {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		c.writeQueue.mutex.Lock()
+
+		if len(c.writeQueue.frames) > 0 {
+			frame := c.writeQueue.frames[0]
+			c.writeQueue.frames = c.writeQueue.frames[1:]
+
+			c.mutex.Lock()
+
+			for _, id := range frame.IDs() {
+				if pendingQuery, ok := c.responses[id]; ok {
+					pendingQuery.written = true
+				}
+			}
+
+			c.mutex.Unlock()
+			c.writeQueue.mutex.Unlock()
+
+			if frame.written != nil {
+				close(frame.written)
+			}
+
+			return frame
+		}
+
+		c.writeQueue.mutex.Unlock()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected a frame to be written")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionStreamFailed(t *testing.T) {
+	c := newTestConnection(t)
+
+	written := NewPendingQuery(1)
+	written.replayable = true
+	written.written = true
+
+	unwritten := NewPendingQuery(1)
+	unwritten.replayable = true
+
+	prepared := NewPendingQuery(1)
+
+	c.responses["written"] = written
+	c.responses["unwritten"] = unwritten
+	c.responses["prepared"] = prepared
+
+	c.writeQueue.Write("unwritten", []byte("unwritten"))
+	c.writeQueue.Write("prepared", []byte("prepared"))
+	c.writeQueue.WriteMessage(QueryStreamCancelQuery, "", []byte("cancel"))
+	c.writeQueue.WriteMessage(QueryStreamPrepareStatement, "unwritten", []byte("prepare"))
+
+	if !c.streamFailed() {
+		t.Error("expected the stream to have been established")
+	}
+
+	select {
+	case <-c.connected:
+		t.Error("expected a new connected channel")
+	default:
+	}
+
+	for _, pendingQuery := range []*PendingQuery{written, prepared} {
+		select {
+		case <-pendingQuery.failed:
+			if !errors.Is(pendingQuery.err, ErrConnectionLost) {
+				t.Errorf("expected %v, got %v", ErrConnectionLost, pendingQuery.err)
+			}
+		default:
+			t.Error("expected the query to fail")
+		}
+	}
+
+	// Only the query that never reached the server is safe to retry
+	if errors.Is(written.err, driver.ErrBadConn) {
+		t.Error("expected the written query not to be retried")
+	}
+
+	if !errors.Is(prepared.err, driver.ErrBadConn) {
+		t.Error("expected the unwritten query to be retried")
+	}
+
+	if _, ok := c.responses["unwritten"]; !ok || len(c.responses) != 1 {
+		t.Errorf("expected only the unwritten query to be pending, got %v", c.responses)
+	}
+
+	frames := c.writeQueue.frames
+
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames to be replayed, got %d", len(frames))
+	}
+
+	if ids := frames[0].IDs(); len(ids) != 1 || ids[0] != "unwritten" {
+		t.Errorf("expected the query frame to keep the unwritten query, got %v", ids)
+	}
+
+	if frames[1].messageType != QueryStreamPrepareStatement {
+		t.Errorf("expected the prepare message to be replayed, got %v", frames[1].messageType)
+	}
+}
+
+func TestConnectionStreamDraining(t *testing.T) {
+	c := newTestConnection(t)
+
+	written := NewPendingQuery(1)
+	written.replayable = true
+	written.written = true
+
+	unwritten := NewPendingQuery(1)
+	unwritten.replayable = true
+
+	c.responses["written"] = written
+	c.responses["unwritten"] = unwritten
+
+	c.writeQueue.Write("unwritten", []byte("unwritten"))
+
+	generation := c.streamDraining()
+
+	// The written query keeps waiting for its response from the draining
+	// stream, the unwritten one moves to the next stream
+	if len(c.responses) != 2 || len(c.writeQueue.frames) != 1 {
+		t.Fatalf("expected both queries to be pending, got %v", c.responses)
+	}
+
+	if c.streamGeneration() == generation {
+		t.Error("expected a new stream generation")
+	}
+
+	// A failing next stream leaves the draining stream alone
+	c.streamFailed()
+
+	if _, ok := c.responses["written"]; !ok {
+		t.Error("expected the written query to be pending")
+	}
+
+	c.streamDrained(generation)
+
+	select {
+	case <-written.failed:
+	default:
+		t.Error("expected the written query to fail once the stream ended")
+	}
+
+	if _, ok := c.responses["unwritten"]; !ok || len(c.responses) != 1 {
+		t.Errorf("expected only the unwritten query to be pending, got %v", c.responses)
+	}
+}
+
+func TestConnectionReadResponsesMessageLength(t *testing.T) {
+	c := newTestConnection(t)
+
+	// The header claims more than the limit, the body is never read
+	header := binary.LittleEndian.AppendUint32([]byte{byte(QueryStreamFrame)}, maxMessageLength+1)
+
+	err := c.readResponses(bytes.NewReader(header), make(chan struct{}))
+
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Errorf("expected %v, got %v", ErrMalformedResponse, err)
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	c := newTestConnection(t)
+	c.connectionError = errors.New("request failed: 503 Service Unavailable")
+
+	written := NewPendingQuery(1)
+	written.replayable = true
+	written.written = true
+
+	unwritten := NewPendingQuery(1)
+	unwritten.replayable = true
+
+	c.responses["written"] = written
+	c.responses["unwritten"] = unwritten
+
+	c.Close()
+
+	if !errors.Is(written.err, ErrConnectionLost) || errors.Is(written.err, driver.ErrBadConn) {
+		t.Errorf("expected %v, got %v", ErrConnectionLost, written.err)
+	}
+
+	// The query never reached the server, database/sql retries it
+	if !errors.Is(unwritten.err, driver.ErrBadConn) || !errors.Is(unwritten.err, c.connectionError) {
+		t.Errorf("expected the connection error as %v, got %v", driver.ErrBadConn, unwritten.err)
+	}
+
+	if len(c.responses) != 0 {
+		t.Errorf("expected no pending queries, got %v", c.responses)
+	}
+}
+
+func TestConnectionRemovePendingQuery(t *testing.T) {
+	c := newTestConnection(t)
+
+	written := NewPendingQuery(1)
+	written.written = true
+
+	c.responses["written"] = written
+	c.responses["queued"] = NewPendingQuery(1)
+	c.responses["cursor"] = NewPendingQuery(1)
+
+	c.writeQueue.Write("queued", []byte("queued"))
+	c.writeQueue.Write("other", []byte("other"))
+	c.writeQueue.WriteMessage(QueryStreamOpenCursor, "cursor", []byte("cursor"))
+	c.writeQueue.WriteMessage(QueryStreamCancelQuery, "", []byte("cancel"))
+
+	// The server has the written query, it must be cancelled there
+	if c.removePendingQuery("written") {
+		t.Error("expected the written query not to be dropped")
+	}
+
+	if !c.removePendingQuery("queued") || !c.removePendingQuery("cursor") {
+		t.Error("expected the queued queries to be dropped")
+	}
+
+	frames := c.writeQueue.frames
+
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames to be queued, got %d", len(frames))
+	}
+
+	if ids := frames[0].IDs(); len(ids) != 1 || ids[0] != "other" {
+		t.Errorf("expected only the other query to be queued, got %v", ids)
+	}
+
+	if frames[1].messageType != QueryStreamCancelQuery {
+		t.Errorf("expected the cancel message to stay queued, got %v", frames[1].messageType)
+	}
+
+	if len(c.responses) != 0 {
+		t.Errorf("expected no pending queries, got %v", c.responses)
+	}
+}
+
+func TestConnectionOpenStreamInvalidStatement(t *testing.T) {
+	c := newTestConnection(t)
+
+	statement, _, _ := c.statements.Add("SELECT 1", "handle", c.statements.Epoch())
+
+	// The stream failed between preparing the statement and sending the query
+	c.statements.Invalidate()
+
+	_, err := c.openStream(context.Background(), "query", 1, false, statement, func() error {
+		t.Error("expected the query not to be written")
+		return nil
+	})
+
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected %v, got %v", driver.ErrBadConn, err)
+	}
+
+	if len(c.responses) != 0 {
+		t.Errorf("expected no pending queries, got %v", c.responses)
+	}
+}
+
+func TestConnectionReadResponsesSlowReader(t *testing.T) {
+	c := newTestConnection(t)
+
+	slow := NewResponseStream(context.Background(), c, "slow", NewPendingQuery(1))
+	fast := NewResponseStream(context.Background(), c, "fast", NewPendingQuery(1))
+
+	c.responses["slow"] = slow.pendingQuery
+	c.responses["fast"] = fast.pendingQuery
+
+	// More responses for the slow query than fit in its buffer arrive before
+	// the response of the other query on the stream
+	var frame []byte
+
+	for i := 0; i < 2*ResponseStreamBufferSize; i++ {
+		frame = append(frame, encodeTestFrameEntry("slow", QueryResponseFlagMoreRows)...)
+	}
+
+	frame = append(frame, encodeTestFrameEntry("slow", 0)...)
+	frame = append(frame, encodeTestFrameEntry("fast", 0)...)
+
+	read := make(chan error)
+
+	go func() {
+		read <- c.readResponses(bytes.NewReader(encodeTestEntry(QueryStreamFrame, frame)), make(chan struct{}))
+	}()
+
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("reading the stream waited for the slow query")
+	}
+
+	if _, err := fast.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow query still gets every response, in order
+	for i := 0; i <= 2*ResponseStreamBufferSize; i++ {
+		response, err := slow.Next()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if last := i == 2*ResponseStreamBufferSize; response.Data.HasMoreRows() == last {
+			t.Fatalf("unexpected flags %d for response %d", response.Data.Flags, i)
+		}
+	}
+
+	if _, err := slow.Next(); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestConnectionReadResponsesError(t *testing.T) {
+	c := newTestConnection(t)
+
+	query := NewResponseStream(context.Background(), c, "query", NewPendingQuery(1))
+	other := NewResponseStream(context.Background(), c, "other", NewPendingQuery(1))
+
+	c.responses["query"] = query.pendingQuery
+	c.responses["other"] = other.pendingQuery
+
+	queryError := []byte{QueryResponseVersion4}
+	queryError = appendLengthPrefixed(queryError, "query")
+	queryError = appendLengthPrefixed(queryError, "")
+	queryError = binary.LittleEndian.AppendUint32(queryError, uint32(ErrorCodeConstraintUnique))
+	queryError = appendLengthPrefixed(queryError, "UNIQUE constraint failed")
+
+	// An error with an id only ends that query
+	body := encodeTestEntry(QueryStreamError, queryError)
+	body = append(body, encodeTestEntry(QueryStreamFrame, encodeTestFrameEntry("other", 0))...)
+
+	if err := c.readResponses(bytes.NewReader(body), make(chan struct{})); err != io.EOF {
+		t.Fatalf("expected the stream to be read to the end, got %v", err)
+	}
+
+	response, err := query.Next()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(response.err(), ErrConstraintUnique) {
+		t.Errorf("expected %v, got %v", ErrConstraintUnique, response.err())
+	}
+
+	response, err = other.Next()
+
+	if err != nil || len(response.Error) > 0 || len(response.Data.Rows) != 2 {
+		t.Errorf("expected the rows of the other query, got %+v, %v", response, err)
+	}
+
+	// Any other error ends the stream
+	c.responses["query"] = NewPendingQuery(1)
+
+	err = c.readResponses(bytes.NewReader(encodeTestEntry(QueryStreamError, []byte("invalid signature"))), make(chan struct{}))
+
+	if err == nil || err.Error() != "invalid signature" {
+		t.Errorf("expected the stream to end with the error, got %v", err)
+	}
+
+	select {
+	case <-c.responses["query"].responses:
+		t.Error("expected the error not to be delivered to a query")
+	default:
+	}
+}
+
+func TestConnectionReadResponsesReaderNeverReads(t *testing.T) {
+	c := newTestConnection(t)
+
+	stuck := NewResponseStream(context.Background(), c, "stuck", NewPendingQuery(ResponseStreamBufferSize))
+	fast := NewResponseStream(context.Background(), c, "fast", NewPendingQuery(1))
+
+	stuck.pendingQuery.written = true
+
+	c.responses["stuck"] = stuck.pendingQuery
+	c.responses["fast"] = fast.pendingQuery
+
+	// Far more responses than the stream buffers and queues arrive for a
+	// query whose rows are never read
+	var frame []byte
+
+	for i := 0; i < 10*(ResponseStreamBufferSize+ResponseStreamMaxQueued); i++ {
+		frame = append(frame, encodeTestFrameEntry("stuck", QueryResponseFlagMoreRows)...)
+	}
+
+	frame = append(frame, encodeTestFrameEntry("fast", 0)...)
+
+	read := make(chan error)
+
+	go func() {
+		read <- c.readResponses(bytes.NewReader(encodeTestEntry(QueryStreamFrame, frame)), make(chan struct{}))
+	}()
+
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("reading the stream waited for the stuck query")
+	}
+
+	if _, err := fast.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the buffered responses are kept, the query is cancelled
+	if n := len(stuck.pendingQuery.responses) + len(stuck.pendingQuery.overflow); n != ResponseStreamBufferSize {
+		t.Errorf("expected %d responses to be kept, got %d", ResponseStreamBufferSize, n)
+	}
+
+	frames := c.writeQueue.frames
+
+	if len(frames) != 1 || frames[0].messageType != QueryStreamCancelQuery {
+		t.Fatalf("expected the query to be cancelled, got %d frames", len(frames))
+	}
+
+	for i := 0; i < ResponseStreamBufferSize; i++ {
+		if _, err := stuck.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := stuck.Next(); !errors.Is(err, ErrReaderTooSlow) {
+		t.Errorf("expected %v, got %v", ErrReaderTooSlow, err)
+	}
+}
+
+func TestConnectionPrepareStreamFailed(t *testing.T) {
+	c := newTestConnection(t)
+
+	prepared := make(chan error)
+
+	go func() {
+		_, err := c.prepare(context.Background(), "SELECT 1")
+		prepared <- err
+	}()
+
+	var id string
+
+	for id == "" {
+		c.mutex.Lock()
+
+		for pendingID := range c.responses {
+			id = pendingID
+		}
+
+		c.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	// The stream fails after the handle was sent, before it is cached
+	c.statements.Invalidate()
+
+	c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte(id), StatementHandle: []byte("handle")}})
+
+	if err := <-prepared; !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected %v, got %v", driver.ErrBadConn, err)
+	}
+
+	if _, ok := c.statements.Acquire("SELECT 1"); ok {
+		t.Error("expected the handle of the failed stream not to be cached")
+	}
+}
+
+func TestConnectionOpenCursor(t *testing.T) {
+	c := newTestConnection(t)
+
+	stream, err := c.OpenCursor(context.Background(), Query{ID: "cursor", Statement: "SELECT 1"}, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := nextTestFrame(t, c)
+
+	if frame.messageType != QueryStreamOpenCursor || binary.LittleEndian.Uint32(frame.payload) != 2 {
+		t.Fatalf("expected the cursor to be opened with a fetch size of 2, got %v", frame.messageType)
+	}
+
+	// The first batch comes with opening the cursor, every later one is
+	// fetched when it is read
+	for i := 0; i < 3; i++ {
+		c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte("cursor"), Flags: QueryResponseFlagMoreRows}})
+
+		if _, err := stream.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		fetches := len(c.writeQueue.frames)
+
+		if i > 0 {
+			frame := nextTestFrame(t, c)
+			fetches--
+
+			if frame.messageType != QueryStreamFetchCursor {
+				t.Fatalf("expected a fetch message, got %v", frame.messageType)
+			}
+
+			if !bytes.Equal(frame.payload, binary.LittleEndian.AppendUint32(appendLengthPrefixed(nil, "cursor"), 2)) {
+				t.Errorf("unexpected fetch message: %v", frame.payload)
+			}
+		}
+
+		if fetches != 0 {
+			t.Fatalf("expected one fetch message per batch, got %d more", fetches)
+		}
+	}
+
+	// Closing before the last batch closes the cursor instead of cancelling
+	stream.Close()
+
+	frame = nextTestFrame(t, c)
+
+	if frame.messageType != QueryStreamCloseCursor || len(c.writeQueue.frames) != 0 {
+		t.Errorf("expected only the cursor to be closed, got %v", frame.messageType)
+	}
+}
@@ -6,17 +6,46 @@ import (
 )
 
 type Connector struct {
+	config Config
 	driver driver.Driver
 	pool   *ConnectionPool
 }
 
+// Create a connector from a Config, for use with sql.OpenDB.
+func NewConnector(config Config) (*Connector, error) {
+	return newConnector(&Driver{}, config)
+}
+
+func newConnector(d driver.Driver, config Config) (*Connector, error) {
+	config, err := config.withDefaults()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Connector{
+		config: config,
+		driver: d,
+		pool:   NewConnectionPool(config),
+	}, nil
+}
+
+// Close the connector, database/sql calls this when the DB is closed. New
+// queries fail right away, running queries get Config.CloseTimeout to finish
+// before every stream to the server is closed.
+func (c *Connector) Close() error {
+	return c.pool.Close()
+}
+
 func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
-	return NewConn(
-		c.pool.url,
-		c.pool,
-	), nil
+	return NewConn(c.config, c.pool), nil
 }
 
 func (c *Connector) Driver() driver.Driver {
 	return c.driver
 }
+
+// Get the current statistics of the connection pool.
+func (c *Connector) Stats() PoolStats {
+	return c.pool.Stats()
+}
@@ -0,0 +1,26 @@
+package sql
+
+import "context"
+
+type contextKey int
+
+const (
+	fetchSizeContextKey contextKey = iota
+)
+
+// WithFetchSize returns a context that runs queries through a server-side
+// cursor that fetches fetchSize rows at a time, overriding the fetchSize
+// connection string option. A fetch size of 0 streams rows without a cursor.
+func WithFetchSize(ctx context.Context, fetchSize int) context.Context {
+	return context.WithValue(ctx, fetchSizeContextKey, fetchSize)
+}
+
+// Get the fetch size for a query from the context, or the default when the
+// context does not set one.
+func fetchSizeFromContext(ctx context.Context, defaultFetchSize int) int {
+	if fetchSize, ok := ctx.Value(fetchSizeContextKey).(int); ok {
+		return fetchSize
+	}
+
+	return defaultFetchSize
+}
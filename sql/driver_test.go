@@ -1,7 +1,9 @@
 package sql_test
 
 import (
+	"crypto/tls"
 	"database/sql"
+	"net/http"
 	"testing"
 
 	litebaseSql "github.com/litebase/litebase-go/sql"
@@ -67,3 +69,56 @@ func TestDriverExec(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNewConnector(t *testing.T) {
+	connector, err := litebaseSql.NewConnector(litebaseSql.Config{
+		URL:             "http://localhost:8080",
+		AccessKeyID:     "test",
+		AccessKeySecret: "test",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+
+	if _, ok := db.Driver().(*litebaseSql.Driver); !ok {
+		t.Fatal("Expected db.Driver() to be of type *Driver")
+	}
+
+	// Fails without credentials
+	_, err = litebaseSql.NewConnector(litebaseSql.Config{
+		URL: "http://localhost:8080",
+	})
+
+	if err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	// Fails with a URL that is not an http or https URL with a host
+	for _, url := range []string{"localhost:8080", "ftp://localhost", "http://", "http://local host"} {
+		_, err = litebaseSql.NewConnector(litebaseSql.Config{
+			URL:             url,
+			AccessKeyID:     "test",
+			AccessKeySecret: "test",
+		})
+
+		if err == nil {
+			t.Fatalf("%q: expected error but got nil", url)
+		}
+	}
+
+	// Fails with both a TLS config and a custom transport
+	_, err = litebaseSql.NewConnector(litebaseSql.Config{
+		URL:             "http://localhost:8080",
+		AccessKeyID:     "test",
+		AccessKeySecret: "test",
+		TLSConfig:       &tls.Config{},
+		Transport:       http.DefaultTransport,
+	})
+
+	if err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+}
@@ -0,0 +1,160 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+// ErrConnectionLost is returned for a query when the stream it was sent over
+// failed before its response arrived. The query may or may not have run on
+// the server, so it is only safe to retry when running it twice is harmless.
+var ErrConnectionLost = errors.New("connection to the server was lost before the response arrived")
+
+// ErrConnectorClosed is returned for queries started after the connector was
+// closed.
+var ErrConnectorClosed = errors.New("connector is closed")
+
+// ErrMalformedResponse is returned when a response from the server is
+// truncated or its lengths do not add up.
+var ErrMalformedResponse = errors.New("malformed response")
+
+// ErrReaderTooSlow is returned for a streamed query whose rows were not read
+// as fast as the server sent them, so more than ResponseStreamMaxQueued
+// responses piled up. The query is cancelled rather than holding up the other
+// queries on the connection. Read large results through a cursor, see
+// Config.FetchSize, so the server only sends rows as they are read.
+var ErrReaderTooSlow = errors.New("query cancelled: rows were not read as fast as the server sent them")
+
+// An error of a query that failed before any of it reached the server. It
+// matches driver.ErrBadConn, which tells database/sql to discard the
+// connection and retry the query on another one.
+type badConnError struct {
+	err error
+}
+
+func badConn(err error) error {
+	return &badConnError{err: err}
+}
+
+func (e *badConnError) Error() string {
+	return e.err.Error()
+}
+
+func (e *badConnError) Unwrap() []error {
+	return []error{driver.ErrBadConn, e.err}
+}
+
+// ErrorCode is a SQLite result code. The primary codes are the lower 8 bits of
+// the extended codes.
+type ErrorCode int
+
+// Primary result codes.
+const (
+	ErrorCodeError      ErrorCode = 1
+	ErrorCodeInternal   ErrorCode = 2
+	ErrorCodePerm       ErrorCode = 3
+	ErrorCodeAbort      ErrorCode = 4
+	ErrorCodeBusy       ErrorCode = 5
+	ErrorCodeLocked     ErrorCode = 6
+	ErrorCodeNoMem      ErrorCode = 7
+	ErrorCodeReadOnly   ErrorCode = 8
+	ErrorCodeInterrupt  ErrorCode = 9
+	ErrorCodeIOErr      ErrorCode = 10
+	ErrorCodeCorrupt    ErrorCode = 11
+	ErrorCodeNotFound   ErrorCode = 12
+	ErrorCodeFull       ErrorCode = 13
+	ErrorCodeCantOpen   ErrorCode = 14
+	ErrorCodeProtocol   ErrorCode = 15
+	ErrorCodeSchema     ErrorCode = 17
+	ErrorCodeTooBig     ErrorCode = 18
+	ErrorCodeConstraint ErrorCode = 19
+	ErrorCodeMismatch   ErrorCode = 20
+	ErrorCodeMisuse     ErrorCode = 21
+	ErrorCodeAuth       ErrorCode = 23
+	ErrorCodeRange      ErrorCode = 25
+	ErrorCodeNotADB     ErrorCode = 26
+)
+
+// Extended result codes.
+const (
+	ErrorCodeBusyRecovery         = ErrorCodeBusy | 1<<8
+	ErrorCodeBusySnapshot         = ErrorCodeBusy | 2<<8
+	ErrorCodeBusyTimeout          = ErrorCodeBusy | 3<<8
+	ErrorCodeReadOnlyRecovery     = ErrorCodeReadOnly | 1<<8
+	ErrorCodeReadOnlyCantLock     = ErrorCodeReadOnly | 2<<8
+	ErrorCodeReadOnlyRollback     = ErrorCodeReadOnly | 3<<8
+	ErrorCodeConstraintCheck      = ErrorCodeConstraint | 1<<8
+	ErrorCodeConstraintForeignKey = ErrorCodeConstraint | 3<<8
+	ErrorCodeConstraintNotNull    = ErrorCodeConstraint | 5<<8
+	ErrorCodeConstraintPrimaryKey = ErrorCodeConstraint | 6<<8
+	ErrorCodeConstraintTrigger    = ErrorCodeConstraint | 7<<8
+	ErrorCodeConstraintUnique     = ErrorCodeConstraint | 8<<8
+	ErrorCodeConstraintRowID      = ErrorCodeConstraint | 10<<8
+	ErrorCodeConstraintDataType   = ErrorCodeConstraint | 12<<8
+	ErrorCodeAbortRollback        = ErrorCodeAbort | 2<<8
+	ErrorCodeLockedSharedCache    = ErrorCodeLocked | 1<<8
+)
+
+// Errors to compare the errors of queries with errors.Is. An error matches a
+// sentinel with an extended code when the extended codes are equal and one
+// with only a primary code when the primary codes are equal.
+var (
+	ErrBusy                 = &Error{Code: ErrorCodeBusy, Message: "database is locked"}
+	ErrLocked               = &Error{Code: ErrorCodeLocked, Message: "database table is locked"}
+	ErrReadOnly             = &Error{Code: ErrorCodeReadOnly, Message: "attempt to write a readonly database"}
+	ErrInterrupt            = &Error{Code: ErrorCodeInterrupt, Message: "interrupted"}
+	ErrFull                 = &Error{Code: ErrorCodeFull, Message: "database or disk is full"}
+	ErrTooBig               = &Error{Code: ErrorCodeTooBig, Message: "string or blob too big"}
+	ErrConstraint           = &Error{Code: ErrorCodeConstraint, Message: "constraint failed"}
+	ErrConstraintCheck      = &Error{Code: ErrorCodeConstraint, ExtendedCode: ErrorCodeConstraintCheck, Message: "CHECK constraint failed"}
+	ErrConstraintForeignKey = &Error{Code: ErrorCodeConstraint, ExtendedCode: ErrorCodeConstraintForeignKey, Message: "FOREIGN KEY constraint failed"}
+	ErrConstraintNotNull    = &Error{Code: ErrorCodeConstraint, ExtendedCode: ErrorCodeConstraintNotNull, Message: "NOT NULL constraint failed"}
+	ErrConstraintPrimaryKey = &Error{Code: ErrorCodeConstraint, ExtendedCode: ErrorCodeConstraintPrimaryKey, Message: "PRIMARY KEY constraint failed"}
+	ErrConstraintUnique     = &Error{Code: ErrorCodeConstraint, ExtendedCode: ErrorCodeConstraintUnique, Message: "UNIQUE constraint failed"}
+)
+
+// Error is the error of a query the server failed to run. Use errors.As to
+// read the result code, or errors.Is to compare it with one of the sentinel
+// errors such as ErrConstraintUnique.
+type Error struct {
+	// The id of the query that failed.
+	QueryID string
+	// The id of the transaction the query ran in, empty outside of one.
+	TransactionID string
+	// The primary SQLite result code, zero when the server did not send one.
+	Code ErrorCode
+	// The extended SQLite result code, zero when the server did not send one.
+	ExtendedCode ErrorCode
+	// The message of the server.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Report whether the error has the result code of a sentinel error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	if !ok {
+		return false
+	}
+
+	if t.ExtendedCode != 0 {
+		return e.ExtendedCode == t.ExtendedCode
+	}
+
+	return t.Code != 0 && e.Code == t.Code
+}
+
+// Create the error of a query from the extended result code the server sent.
+func newError(queryID, transactionID string, extendedCode ErrorCode, message string) *Error {
+	return &Error{
+		QueryID:       queryID,
+		TransactionID: transactionID,
+		Code:          extendedCode & 0xff,
+		ExtendedCode:  extendedCode,
+		Message:       message,
+	}
+}
@@ -0,0 +1,75 @@
+package sql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("insert: %w", newError("query", "", ErrorCodeConstraintUnique, "UNIQUE constraint failed: users.email"))
+
+	if !errors.Is(err, ErrConstraintUnique) {
+		t.Error("expected the error to match ErrConstraintUnique")
+	}
+
+	if !errors.Is(err, ErrConstraint) {
+		t.Error("expected the error to match ErrConstraint")
+	}
+
+	if errors.Is(err, ErrConstraintPrimaryKey) || errors.Is(err, ErrBusy) {
+		t.Error("expected the error not to match other result codes")
+	}
+
+	var queryError *Error
+
+	if !errors.As(err, &queryError) {
+		t.Fatal("expected the error to be an *Error")
+	}
+
+	if queryError.QueryID != "query" || queryError.Code != ErrorCodeConstraint || queryError.ExtendedCode != 2067 {
+		t.Errorf("unexpected error: %+v", queryError)
+	}
+
+	// Servers before version 4 send no result code
+	if errors.Is(newError("query", "", 0, "database is locked"), ErrBusy) {
+		t.Error("expected an error without a result code not to match ErrBusy")
+	}
+}
+
+func TestDecodeErrorEntry(t *testing.T) {
+	entry := []byte{QueryResponseVersion4}
+
+	for _, field := range []string{"query", "transaction"} {
+		entry = binary.LittleEndian.AppendUint32(entry, uint32(len(field)))
+		entry = append(entry, field...)
+	}
+
+	entry = binary.LittleEndian.AppendUint32(entry, uint32(ErrorCodeBusySnapshot))
+	entry = binary.LittleEndian.AppendUint32(entry, uint32(len("database is locked")))
+	entry = append(entry, "database is locked"...)
+
+	response, err := decodeErrorEntry(entry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = response.err()
+
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("expected %v to match ErrBusy", err)
+	}
+
+	var queryError *Error
+
+	if !errors.As(err, &queryError) || queryError.TransactionID != "transaction" || queryError.ExtendedCode != ErrorCodeBusySnapshot {
+		t.Errorf("unexpected error: %+v", queryError)
+	}
+
+	// A truncated entry is not an error entry
+	if _, err := decodeErrorEntry(entry[:len(entry)-1]); !errors.Is(err, ErrMalformedResponse) {
+		t.Error("expected a truncated entry not to decode")
+	}
+}
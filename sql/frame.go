@@ -8,49 +8,124 @@ import (
 const MaxFrameSize = 100
 
 type Frame struct {
-	closed  bool
-	mutex   *sync.Mutex
-	queries [][]byte
+	closed      bool
+	ids         []string
+	messageType QueryStreamMessageType
+	mutex       *sync.Mutex
+	payload     []byte
+	queries     [][]byte
+	written     chan struct{}
 }
 
 func NewFrame() *Frame {
 	return &Frame{
-		closed: false,
-		mutex:  &sync.Mutex{},
+		closed:      false,
+		messageType: QueryStreamFrame,
+		mutex:       &sync.Mutex{},
 	}
 }
 
-func (f *Frame) AddQuery(query []byte) {
+// NewMessageFrame creates a frame that carries a single control message, such
+// as a query cancellation, instead of query requests. The frame is closed from
+// the start so no queries are added to it. The id is the one of the query the
+// server responds to the message with, or empty when it does not respond.
+func NewMessageFrame(messageType QueryStreamMessageType, id string, payload []byte) *Frame {
+	frame := &Frame{
+		closed:      true,
+		messageType: messageType,
+		mutex:       &sync.Mutex{},
+		payload:     payload,
+	}
+
+	if id != "" {
+		frame.ids = []string{id}
+	}
+
+	return frame
+}
+
+// Build the frame data from the query requests, or use the payload for
+// control message frames.
+func (f *Frame) data() []byte {
+	if f.messageType != QueryStreamFrame {
+		return f.payload
+	}
+
+	frameData := []byte{}
+
+	for _, queryRequest := range f.queries {
+		frameData = binary.LittleEndian.AppendUint32(frameData, uint32(len(queryRequest))) // Query request length
+		frameData = append(frameData, queryRequest...)                                     // Query request
+	}
+
+	return frameData
+}
+
+func (f *Frame) AddQuery(id string, query []byte) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
+	f.ids = append(f.ids, id)
 	f.queries = append(f.queries, query)
 }
 
-func (f *Frame) Encode() []byte {
+// Get the ids of the queries the server responds to the frame with.
+func (f *Frame) IDs() []string {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	f.closed = true
+	return f.ids
+}
 
-	// Write the message type to be a QueryStreamFrame
-	frame := []byte{byte(QueryStreamFrame)}
+// Remove the queries the keep function returns false for, a message frame is
+// kept as a whole when its id is kept. Messages without an id are passed to
+// the keep function with an empty id. Returns false when nothing is left to
+// write.
+func (f *Frame) retain(keep func(id string) bool) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
 
-	queryDataLength := 0
+	if f.messageType != QueryStreamFrame {
+		if len(f.ids) == 0 {
+			return keep("")
+		}
 
-	for _, queryRequest := range f.queries {
-		queryDataLength += len(queryRequest) + 4 // 4 bytes for the length of the query
+		return keep(f.ids[0])
 	}
 
-	// Write the length of the frame
-	frame = binary.LittleEndian.AppendUint32(frame, uint32(queryDataLength)) // Frame length
+	ids := f.ids[:0]
+	queries := f.queries[:0]
 
-	// Write the query requests
-	for _, queryRequest := range f.queries {
-		frame = binary.LittleEndian.AppendUint32(frame, uint32(len(queryRequest))) // Query request length
-		frame = append(frame, queryRequest...)                                     // Query request
+	for i, id := range f.ids {
+		if keep(id) {
+			ids = append(ids, id)
+			queries = append(queries, f.queries[i])
+		}
 	}
 
+	f.ids = ids
+	f.queries = queries
+
+	return len(f.queries) > 0
+}
+
+func (f *Frame) Encode() []byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.closed = true
+
+	// Write the message type of the frame
+	frame := []byte{byte(f.messageType)}
+
+	frameData := f.data()
+
+	// Write the length of the frame
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(frameData))) // Frame length
+
+	// Write the frame data
+	frame = append(frame, frameData...)
+
 	return frame
 }
 
@@ -68,24 +143,19 @@ func (f *Frame) EncodeWithSignature(accessKeySecret, date, previousSignature str
 	f.closed = true
 
 	// Build the frame data first (without the signature header)
-	frameData := []byte{}
-	
-	for _, queryRequest := range f.queries {
-		frameData = binary.LittleEndian.AppendUint32(frameData, uint32(len(queryRequest))) // Query request length
-		frameData = append(frameData, queryRequest...)                                     // Query request
-	}
+	frameData := f.data()
 
 	// Calculate the chunk signature for this frame data
 	chunkSignature := SignChunk(accessKeySecret, date, previousSignature, frameData)
 
 	// Now build the complete frame with signature metadata
 	// Format: [MessageType:1][FrameLength:4][SignatureLength:4][Signature:N][FrameData]
-	frame := []byte{byte(QueryStreamFrame)}
-	
+	frame := []byte{byte(f.messageType)}
+
 	// Calculate total length: signature length (4) + signature + frame data
 	signatureBytes := []byte(chunkSignature)
 	totalLength := 4 + len(signatureBytes) + len(frameData)
-	
+
 	frame = binary.LittleEndian.AppendUint32(frame, uint32(totalLength))
 	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(signatureBytes)))
 	frame = append(frame, signatureBytes...)
@@ -112,5 +182,6 @@ func (f *Frame) Write(query []byte) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
+	f.ids = append(f.ids, "")
 	f.queries = append(f.queries, query)
 }
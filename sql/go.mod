@@ -3,7 +3,6 @@ module github.com/litebase/litebase-go/sql
 go 1.23
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8 // indirect
-	golang.org/x/sync v0.10.0 // indirect
+	github.com/google/uuid v1.6.0
+	golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8
 )
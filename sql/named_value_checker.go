@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// TimeEncoding is the storage class and format time.Time arguments are bound
+// with. It is set with the timeEncoding connection string option.
+type TimeEncoding string
+
+const (
+	// Text in ParameterTimeLayout, e.g. 2025-01-02T03:04:05.000006000Z.
+	TimeEncodingText TimeEncoding = "text"
+	// Integer seconds since the Unix epoch.
+	TimeEncodingUnix TimeEncoding = "unix"
+	// Integer milliseconds since the Unix epoch.
+	TimeEncodingUnixMilli TimeEncoding = "unixmilli"
+	// Integer nanoseconds since the Unix epoch.
+	TimeEncodingUnixNano TimeEncoding = "unixnano"
+)
+
+// Parse a time encoding name, the empty string selects TimeEncodingText.
+func ParseTimeEncoding(name string) (TimeEncoding, error) {
+	switch TimeEncoding(name) {
+	case "", TimeEncodingText:
+		return TimeEncodingText, nil
+	case TimeEncodingUnix, TimeEncodingUnixMilli, TimeEncodingUnixNano:
+		return TimeEncoding(name), nil
+	default:
+		return "", fmt.Errorf("unsupported time encoding: %s", name)
+	}
+}
+
+// Convert an argument to a value of one of the Litebase storage classes:
+//
+//   - nil and nil pointers are bound as NULL.
+//   - driver.Valuer values are bound as the value they return.
+//   - Signed integers are bound as INTEGER.
+//   - Unsigned integers are bound as INTEGER, values above math.MaxInt64
+//     return an overflow error.
+//   - float32 and float64 are bound as FLOAT.
+//   - bool is bound as the INTEGER 0 or 1.
+//   - string is bound as TEXT and json.RawMessage is bound as TEXT so the
+//     SQLite JSON functions accept it.
+//   - []byte is bound as BLOB.
+//   - time.Time is bound according to the TimeEncoding.
+//   - Pointers are bound as the value they point to and types defined on one
+//     of the types above are bound as their underlying type.
+//
+// Any other type returns an error.
+func convertValue(value any, timeEncoding TimeEncoding) (driver.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case int64, float64, bool, string:
+		return v, nil
+	case json.RawMessage:
+		if v == nil {
+			return nil, nil
+		}
+
+		return string(v), nil
+	case []byte:
+		return v, nil
+	case time.Time:
+		return convertTime(v, timeEncoding)
+	case driver.Valuer:
+		rv := reflect.ValueOf(v)
+
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil, nil
+		}
+
+		value, err := v.Value()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return convertValue(value, timeEncoding)
+	}
+
+	rv := reflect.ValueOf(value)
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+
+		return convertValue(rv.Elem().Interface(), timeEncoding)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("unsigned integer %d overflows int64", u)
+		}
+
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported argument type: %T", value)
+}
+
+func convertTime(t time.Time, timeEncoding TimeEncoding) (driver.Value, error) {
+	switch timeEncoding {
+	case "", TimeEncodingText:
+		return formatTime(t), nil
+	case TimeEncodingUnix:
+		return t.Unix(), nil
+	case TimeEncodingUnixMilli:
+		return t.UnixMilli(), nil
+	case TimeEncodingUnixNano:
+		return t.UnixNano(), nil
+	default:
+		return nil, fmt.Errorf("unsupported time encoding: %s", timeEncoding)
+	}
+}
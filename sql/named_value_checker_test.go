@@ -0,0 +1,107 @@
+package sql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testStatus string
+
+type testLevel uint8
+
+type testValuer struct {
+	value driver.Value
+	err   error
+}
+
+func (v *testValuer) Value() (driver.Value, error) {
+	return v.value, v.err
+}
+
+func TestConvertValue(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 6000, time.UTC)
+	number := 42
+
+	var nilInt *int
+	var nilValuer *testValuer
+
+	tests := []struct {
+		name         string
+		value        any
+		timeEncoding TimeEncoding
+		expected     driver.Value
+	}{
+		{"nil", nil, "", nil},
+		{"int", 42, "", int64(42)},
+		{"int8", int8(-8), "", int64(-8)},
+		{"uint32", uint32(32), "", int64(32)},
+		{"max uint64 that fits", uint64(math.MaxInt64), "", int64(math.MaxInt64)},
+		{"float32", float32(0.5), "", float64(0.5)},
+		{"bool", true, "", true},
+		{"string", "litebase", "", "litebase"},
+		{"bytes", []byte{1, 2}, "", []byte{1, 2}},
+		{"json", json.RawMessage(`{"a":1}`), "", `{"a":1}`},
+		{"nil json", json.RawMessage(nil), "", nil},
+		{"named string", testStatus("active"), "", "active"},
+		{"named uint8", testLevel(3), "", int64(3)},
+		{"pointer", &number, "", int64(42)},
+		{"nil pointer", nilInt, "", nil},
+		{"valuer", &testValuer{value: "value"}, "", "value"},
+		{"nil valuer", nilValuer, "", nil},
+		{"null string", sql.NullString{}, "", nil},
+		{"valid null int64", sql.NullInt64{Int64: 7, Valid: true}, "", int64(7)},
+		{"time as text", now, TimeEncodingText, "2025-01-02T03:04:05.000006000Z"},
+		{"time as default", now, "", "2025-01-02T03:04:05.000006000Z"},
+		{"time in another zone as text", now.In(time.FixedZone("", -5*60*60)), TimeEncodingText, "2025-01-02T03:04:05.000006000Z"},
+		{"time as unix", now, TimeEncodingUnix, now.Unix()},
+		{"time as unixmilli", now, TimeEncodingUnixMilli, now.UnixMilli()},
+		{"time as unixnano", now, TimeEncodingUnixNano, now.UnixNano()},
+		{"time pointer", &now, TimeEncodingUnix, now.Unix()},
+	}
+
+	for _, test := range tests {
+		value, err := convertValue(test.value, test.timeEncoding)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, value)
+		}
+	}
+}
+
+func TestConvertValueErrors(t *testing.T) {
+	valuerErr := errors.New("valuer failed")
+
+	tests := []struct {
+		name         string
+		value        any
+		timeEncoding TimeEncoding
+	}{
+		{"uint64 overflow", uint64(math.MaxInt64) + 1, ""},
+		{"uint overflow", uint(math.MaxUint), ""},
+		{"valuer error", &testValuer{err: valuerErr}, ""},
+		{"unsupported type", struct{}{}, ""},
+		{"unsupported slice", []int{1}, ""},
+		{"unsupported time encoding", time.Now(), TimeEncoding("rfc822")},
+	}
+
+	for _, test := range tests {
+		if _, err := convertValue(test.value, test.timeEncoding); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	if _, err := convertValue(&testValuer{err: valuerErr}, ""); !errors.Is(err, valuerErr) {
+		t.Errorf("expected %v, got %v", valuerErr, err)
+	}
+}
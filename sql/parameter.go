@@ -2,7 +2,6 @@ package sql
 
 import (
 	"database/sql/driver"
-	"fmt"
 )
 
 type Parameter struct {
@@ -10,61 +9,60 @@ type Parameter struct {
 	Value any    `json:"value"`
 }
 
-func prepareParameters(args []driver.Value) ([]Parameter, error) {
-	parameters := make([]Parameter, len(args))
+// Convert positional driver values into named values with their ordinal set.
+func namedValues(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
 
 	for i, arg := range args {
-		paramType := "TEXT"
-
-		switch v := arg.(type) {
-		case int64:
-			paramType = "INTEGER"
-		case float64:
-			paramType = "FLOAT"
-		case []byte:
-			paramType = "BLOB"
-		case string:
-			paramType = "TEXT"
-		case nil:
-			paramType = "NULL"
-		default:
-			return nil, fmt.Errorf("unsupported parameter type: %T", v)
+		values[i] = driver.NamedValue{
+			Ordinal: i + 1,
+			Value:   arg,
 		}
+	}
+
+	return values
+}
 
-		parameters[i] = Parameter{
-			Type:  paramType,
-			Value: arg,
+func hasNamedValues(args []driver.NamedValue) bool {
+	for _, arg := range args {
+		if arg.Name != "" {
+			return true
 		}
 	}
-	return parameters, nil
+
+	return false
 }
 
-func prepareParametersNamed(args []driver.NamedValue) ([]Parameter, error) {
+// Prepare positional parameters in the order of the arguments.
+func prepareParameters(args []driver.NamedValue) ([]Parameter, error) {
 	parameters := make([]Parameter, len(args))
 
 	for i, arg := range args {
-		paramType := "TEXT"
-
-		switch v := arg.Value.(type) {
-		case int64:
-			paramType = "INTEGER"
-		case float64:
-			paramType = "FLOAT"
-		case []byte:
-			paramType = "BLOB"
-		case string:
-			paramType = "TEXT"
-		case nil:
-			paramType = "NULL"
-		default:
-			return nil, fmt.Errorf("unsupported parameter type: %T", v)
-		}
+		parameter, err := newParameter(arg.Value)
 
-		parameters[i] = Parameter{
-			Type:  paramType,
-			Value: arg.Value,
+		if err != nil {
+			return nil, err
 		}
+
+		parameters[i] = parameter
 	}
 
 	return parameters, nil
 }
+
+// Prepare the parameters for a statement that has not been prepared. The
+// statement is only parsed for its bind parameters when named arguments need
+// to be resolved.
+func prepareParametersNamed(sql string, args []driver.NamedValue) ([]Parameter, error) {
+	if !hasNamedValues(args) {
+		return prepareParameters(args)
+	}
+
+	bindParameters, err := parseBindParameters(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bindParameters.prepare(args)
+}
@@ -0,0 +1,141 @@
+package sql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Parameter types as they are named in a Parameter.
+const (
+	ParameterTypeInteger = "INTEGER"
+	ParameterTypeFloat   = "FLOAT"
+	ParameterTypeText    = "TEXT"
+	ParameterTypeBlob    = "BLOB"
+	ParameterTypeNull    = "NULL"
+)
+
+// The layout time values are bound with, in UTC. SQLite date and time
+// functions understand it, and since every value has the same width and zone
+// it sorts correctly as text.
+const ParameterTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Format a time in ParameterTimeLayout.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(ParameterTimeLayout)
+}
+
+// Create a parameter from a driver value. Values are normalized to the storage
+// class they are bound as: booleans become the integers 0 and 1 and times
+// become text in ParameterTimeLayout.
+func newParameter(value driver.Value) (Parameter, error) {
+	switch v := value.(type) {
+	case int64:
+		return Parameter{Type: ParameterTypeInteger, Value: v}, nil
+	case float64:
+		return Parameter{Type: ParameterTypeFloat, Value: v}, nil
+	case bool:
+		if v {
+			return Parameter{Type: ParameterTypeInteger, Value: int64(1)}, nil
+		}
+
+		return Parameter{Type: ParameterTypeInteger, Value: int64(0)}, nil
+	case string:
+		return Parameter{Type: ParameterTypeText, Value: v}, nil
+	case []byte:
+		if v == nil {
+			return Parameter{Type: ParameterTypeNull}, nil
+		}
+
+		return Parameter{Type: ParameterTypeBlob, Value: v}, nil
+	case time.Time:
+		return Parameter{Type: ParameterTypeText, Value: formatTime(v)}, nil
+	case nil:
+		return Parameter{Type: ParameterTypeNull}, nil
+	default:
+		return Parameter{}, fmt.Errorf("unsupported parameter type: %T", v)
+	}
+}
+
+// Encode a parameter as [Type:1][ValueLength:4][Value], the same layout the
+// server uses for column values in a row.
+func encodeParameter(buffer *bytes.Buffer, parameter Parameter) error {
+	switch parameter.Type {
+	case ParameterTypeInteger:
+		value, ok := parameter.Value.(int64)
+
+		if !ok {
+			return fmt.Errorf("invalid %s parameter value: %T", parameter.Type, parameter.Value)
+		}
+
+		buffer.WriteByte(byte(ColumnTypeInteger))
+		buffer.Write(binary.LittleEndian.AppendUint32(nil, 8))
+		buffer.Write(binary.LittleEndian.AppendUint64(nil, uint64(value)))
+	case ParameterTypeFloat, "REAL":
+		value, ok := parameter.Value.(float64)
+
+		if !ok {
+			return fmt.Errorf("invalid %s parameter value: %T", parameter.Type, parameter.Value)
+		}
+
+		buffer.WriteByte(byte(ColumnTypeFloat))
+		buffer.Write(binary.LittleEndian.AppendUint32(nil, 8))
+		buffer.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(value)))
+	case ParameterTypeText:
+		value, ok := parameter.Value.(string)
+
+		if !ok {
+			return fmt.Errorf("invalid %s parameter value: %T", parameter.Type, parameter.Value)
+		}
+
+		buffer.WriteByte(byte(ColumnTypeText))
+		buffer.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(value))))
+		buffer.WriteString(value)
+	case ParameterTypeBlob:
+		value, ok := parameter.Value.([]byte)
+
+		if !ok {
+			return fmt.Errorf("invalid %s parameter value: %T", parameter.Type, parameter.Value)
+		}
+
+		buffer.WriteByte(byte(ColumnTypeBlob))
+		buffer.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(value))))
+		buffer.Write(value)
+	case ParameterTypeNull:
+		buffer.WriteByte(byte(ColumnTypeNull))
+		buffer.Write(binary.LittleEndian.AppendUint32(nil, 0))
+	default:
+		return fmt.Errorf("unsupported parameter type: %s", parameter.Type)
+	}
+
+	return nil
+}
+
+// Decode the raw column value into the Go type that matches its storage class.
+func decodeColumnValue(column Column) (driver.Value, error) {
+	switch column.Type {
+	case ColumnTypeInteger:
+		if len(column.Value) != 8 {
+			return nil, fmt.Errorf("invalid integer value length: %d", len(column.Value))
+		}
+
+		return int64(binary.LittleEndian.Uint64(column.Value)), nil
+	case ColumnTypeFloat:
+		if len(column.Value) != 8 {
+			return nil, fmt.Errorf("invalid float value length: %d", len(column.Value))
+		}
+
+		return math.Float64frombits(binary.LittleEndian.Uint64(column.Value)), nil
+	case ColumnTypeText:
+		return string(column.Value), nil
+	case ColumnTypeBlob:
+		return column.Value, nil
+	case ColumnTypeNull:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported column type: %d", column.Type)
+	}
+}
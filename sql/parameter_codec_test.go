@@ -0,0 +1,109 @@
+package sql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParameterCodecRoundTrip(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    driver.Value
+		expected driver.Value
+	}{
+		{"int64", int64(42), int64(42)},
+		{"negative int64", int64(-42), int64(-42)},
+		{"max int64", int64(math.MaxInt64), int64(math.MaxInt64)},
+		{"min int64", int64(math.MinInt64), int64(math.MinInt64)},
+		{"float64", float64(3.14), float64(3.14)},
+		{"negative float64", float64(-0.5), float64(-0.5)},
+		{"true", true, int64(1)},
+		{"false", false, int64(0)},
+		{"string", "litebase", "litebase"},
+		{"empty string", "", ""},
+		{"bytes", []byte{0x00, 0x01, 0xff}, []byte{0x00, 0x01, 0xff}},
+		{"empty bytes", []byte{}, []byte{}},
+		{"nil bytes", []byte(nil), nil},
+		{"nil", nil, nil},
+		{"time", now, "2025-01-02T03:04:05.000006000Z"},
+		{"time in whole seconds", now.Truncate(time.Second), "2025-01-02T03:04:05.000000000Z"},
+		{"time in another zone", now.In(time.FixedZone("", 3*60*60)), "2025-01-02T03:04:05.000006000Z"},
+	}
+
+	parametersBuffer := &bytes.Buffer{}
+
+	for _, test := range tests {
+		parameter, err := newParameter(test.value)
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if err := encodeParameter(parametersBuffer, parameter); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+	}
+
+	// Decode the parameters the same way the columns of a row are decoded.
+	rowBytes := binary.LittleEndian.AppendUint32(nil, uint32(parametersBuffer.Len()))
+	rowBytes = append(rowBytes, parametersBuffer.Bytes()...)
+
+	rows, err := decodeRows(1, len(tests), rowBytes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+
+	for i, test := range tests {
+		value, err := decodeColumnValue(rows[0][i])
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, value)
+		}
+	}
+}
+
+func TestParameterCodecErrors(t *testing.T) {
+	if _, err := newParameter(struct{}{}); err == nil {
+		t.Error("Expected an error for an unsupported value type")
+	}
+
+	invalid := []Parameter{
+		{Type: ParameterTypeInteger, Value: 1},
+		{Type: ParameterTypeFloat, Value: "1.5"},
+		{Type: ParameterTypeText, Value: []byte("text")},
+		{Type: ParameterTypeBlob, Value: "blob"},
+		{Type: "DATE", Value: "2025-01-01"},
+	}
+
+	for _, parameter := range invalid {
+		if err := encodeParameter(&bytes.Buffer{}, parameter); err == nil {
+			t.Errorf("Expected an error encoding %#v", parameter)
+		}
+	}
+
+	_, err := QueryRequestEncoder(
+		Query{ID: "1", Statement: "SELECT ?", Parameters: invalid[:1]},
+		&bytes.Buffer{},
+		&bytes.Buffer{},
+	)
+
+	if err == nil {
+		t.Error("Expected an error encoding a query with an invalid parameter")
+	}
+}
@@ -0,0 +1,90 @@
+package sql
+
+import "sync"
+
+// PendingQuery receives the responses for a query that was sent over a
+// connection until the caller stops waiting for them.
+type PendingQuery struct {
+	done       chan struct{}
+	err        error
+	failed     chan struct{}
+	generation int
+	mutex      *sync.Mutex
+	overflow   []QueryResponse
+	replayable bool
+	responses  chan QueryResponse
+	written    bool
+}
+
+func NewPendingQuery(bufferSize int) *PendingQuery {
+	return &PendingQuery{
+		done:      make(chan struct{}),
+		failed:    make(chan struct{}),
+		mutex:     &sync.Mutex{},
+		responses: make(chan QueryResponse, bufferSize),
+	}
+}
+
+// Stop waiting for responses. Responses that arrive afterwards are dropped.
+func (p *PendingQuery) Close() {
+	close(p.done)
+}
+
+// Hand a response to the caller, or drop it when the caller stopped waiting.
+// This never waits, since the connection reads the responses of every query
+// on the stream. Responses that do not fit in the buffer of a slow caller are
+// queued until it catches up, up to ResponseStreamMaxQueued of them. Returns
+// false when the queue is full, the queued responses are dropped then.
+func (p *PendingQuery) deliver(response QueryResponse) bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.overflow) == 0 {
+		select {
+		case p.responses <- response:
+			return true
+		default:
+		}
+	}
+
+	if len(p.overflow) == ResponseStreamMaxQueued {
+		p.overflow = nil
+
+		return false
+	}
+
+	p.overflow = append(p.overflow, response)
+
+	return true
+}
+
+// Move the responses that did not fit into the buffer, in the order they
+// arrived. The caller calls this after each response it reads.
+func (p *PendingQuery) refill() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for len(p.overflow) > 0 {
+		select {
+		case p.responses <- p.overflow[0]:
+			p.overflow = p.overflow[1:]
+		default:
+			return
+		}
+	}
+
+	p.overflow = nil
+}
+
+// End the query with an error instead of a response. The connection calls
+// this once, when it removes the query from its pending queries.
+func (p *PendingQuery) fail(err error) {
+	p.err = err
+	close(p.failed)
+}
@@ -1,8 +1,10 @@
 package sql
 
 type Query struct {
-	ID            string      `json:"id"`
-	Statement     string      `json:"statement"`
-	Parameters    []Parameter `json:"parameters"`
-	TransactionID string      `json:"transactionId"`
+	ID              string      `json:"id"`
+	Statement       string      `json:"statement"`
+	Parameters      []Parameter `json:"parameters"`
+	Prepare         bool        `json:"-"`
+	StatementHandle string      `json:"statementHandle"`
+	TransactionID   string      `json:"transactionId"`
 }
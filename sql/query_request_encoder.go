@@ -9,7 +9,7 @@ func QueryRequestEncoder(
 	query Query,
 	outputBuffer *bytes.Buffer,
 	parametersBuffer *bytes.Buffer,
-) []byte {
+) ([]byte, error) {
 	outputBuffer.Reset()
 	parametersBuffer.Reset()
 	var transactionId string
@@ -17,39 +17,18 @@ func QueryRequestEncoder(
 	id := query.ID
 	statement := query.Statement
 
+	// The statement text is not sent when a prepared statement is used
+	if query.StatementHandle != "" {
+		statement = ""
+	}
+
 	if query.TransactionID != "" {
 		transactionId = query.TransactionID
 	}
 
 	for _, parameter := range query.Parameters {
-		parameterType := parameter.Type
-
-		// Write the value length
-		switch parameterType {
-		case "INTEGER":
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeInteger))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(8))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint64(parameter.Value.(int)))
-		case "FLOAT":
-		case "REAL":
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeFloat))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(8))
-			binary.Write(parametersBuffer, binary.LittleEndian, parameter.Value.(float64))
-		case "TEXT":
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeText))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(len(parameter.Value.(string))))
-			parametersBuffer.Write([]byte(parameter.Value.(string)))
-		case "BLOB":
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeBlob))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(len(parameter.Value.([]byte))))
-			parametersBuffer.Write(parameter.Value.([]byte))
-		case "NULL":
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeNull))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(0))
-		default:
-			// Unsupported parameter type
-			binary.Write(parametersBuffer, binary.LittleEndian, uint8(ColumnTypeUnknown))
-			binary.Write(parametersBuffer, binary.LittleEndian, uint32(0))
+		if err := encodeParameter(parametersBuffer, parameter); err != nil {
+			return nil, err
 		}
 	}
 
@@ -79,5 +58,13 @@ func QueryRequestEncoder(
 	// Write the parameters array
 	outputBuffer.Write(parametersBuffer.Bytes())
 
-	return outputBuffer.Bytes()
+	if query.StatementHandle != "" {
+		// Write the length of the statement handle
+		binary.Write(outputBuffer, binary.LittleEndian, uint32(len(query.StatementHandle)))
+
+		// Write the statement handle
+		outputBuffer.Write([]byte(query.StatementHandle))
+	}
+
+	return outputBuffer.Bytes(), nil
 }
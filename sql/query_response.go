@@ -1,12 +1,39 @@
 package sql
 
+// Response versions sent by the server. Each version adds to the encoding of
+// the previous one.
+const (
+	// Columns carry the name and storage class.
+	QueryResponseVersion1 byte = 1
+	// Columns also carry the declared type and nullability.
+	QueryResponseVersion2 byte = 2
+	// Responses carry flags after the transaction id.
+	QueryResponseVersion3 byte = 3
+	// Errors carry the extended result code after the transaction id.
+	QueryResponseVersion4 byte = 4
+)
+
+// Flags of a response.
+const (
+	// More result sets for the same query follow this one.
+	QueryResponseFlagMoreResultSets byte = 0x01
+	// More rows of the same result set follow in the next response.
+	QueryResponseFlagMoreRows byte = 0x02
+)
+
 type QueryResponse struct {
 	Data  QueryResponseData
 	Error []byte
+	// The extended SQLite result code of the error, sent since version 4.
+	ErrorCode ErrorCode
+	// The result sets after the first one when a query runs several
+	// statements.
+	ResultSets []QueryResponseData
 }
 
 type QueryResponseData struct {
 	Version         byte
+	Flags           byte
 	Changes         int64
 	Latency         float64
 	ColumnsCount    int
@@ -15,5 +42,23 @@ type QueryResponseData struct {
 	ID              []byte
 	Columns         []ColumnDefinition
 	Rows            [][]Column
+	StatementHandle []byte
 	TransactionId   []byte
 }
+
+// Get the error of a response, nil when the query succeeded.
+func (r QueryResponse) err() error {
+	if len(r.Error) == 0 {
+		return nil
+	}
+
+	return newError(string(r.Data.ID), string(r.Data.TransactionId), r.ErrorCode, string(r.Error))
+}
+
+func (d QueryResponseData) HasMoreResultSets() bool {
+	return d.Flags&QueryResponseFlagMoreResultSets != 0
+}
+
+func (d QueryResponseData) HasMoreRows() bool {
+	return d.Flags&QueryResponseFlagMoreRows != 0
+}
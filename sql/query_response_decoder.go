@@ -3,174 +3,430 @@ package sql
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
+// The most columns a result set has, the limit SQLite allows.
+const maxResponseColumns = 32767
+
 type QueryStreamMessageType int
 
 const (
-	QueryStreamOpenConnection  QueryStreamMessageType = 0x01
-	QueryStreamCloseConnection QueryStreamMessageType = 0x02
-	QueryStreamError           QueryStreamMessageType = 0x03
-	QueryStreamFrame           QueryStreamMessageType = 0x04
-	QueryStreamFrameEntry      QueryStreamMessageType = 0x05
+	QueryStreamOpenConnection   QueryStreamMessageType = 0x01
+	QueryStreamCloseConnection  QueryStreamMessageType = 0x02
+	QueryStreamError            QueryStreamMessageType = 0x03
+	QueryStreamFrame            QueryStreamMessageType = 0x04
+	QueryStreamFrameEntry       QueryStreamMessageType = 0x05
+	QueryStreamCancelQuery      QueryStreamMessageType = 0x06
+	QueryStreamPrepareStatement QueryStreamMessageType = 0x07
+	QueryStreamCloseStatement   QueryStreamMessageType = 0x08
+	QueryStreamOpenCursor       QueryStreamMessageType = 0x09
+	QueryStreamFetchCursor      QueryStreamMessageType = 0x0A
+	QueryStreamCloseCursor      QueryStreamMessageType = 0x0B
 )
 
-func QueryResponseDecoder(buffer *bytes.Buffer) []QueryResponse {
-	responses := []QueryResponse{}
-	messageType := buffer.Next(1)[0]
+// Decode the next entry of a frame. An error is returned when the entry is
+// truncated or its lengths do not add up, the rest of the buffer cannot be
+// decoded then.
+func QueryResponseDecoder(buffer *bytes.Buffer) ([]QueryResponse, error) {
+	if buffer.Len() < 5 {
+		return nil, fmt.Errorf("%w: entry header is truncated", ErrMalformedResponse)
+	}
+
+	messageType := QueryStreamMessageType(buffer.Next(1)[0])
+	responseLength := int(binary.LittleEndian.Uint32(buffer.Next(4)))
+
+	if responseLength > buffer.Len() {
+		return nil, fmt.Errorf("%w: entry of %d bytes has %d bytes", ErrMalformedResponse, responseLength, buffer.Len())
+	}
 
-	switch QueryStreamMessageType(messageType) {
+	// Copy the response out of the buffer, the buffer is pooled and reused
+	// while the decoded values are still being read.
+	response := bytes.Clone(buffer.Next(responseLength))
+
+	var queryResponse QueryResponse
+	var err error
+
+	switch messageType {
 	case QueryStreamError:
-		responseLength := buffer.Next(4)
-		response := buffer.Next(int(binary.LittleEndian.Uint32(responseLength)))
-		version := response[0]
-		offset := 1
-		idLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		id := response[offset : offset+idLength]
-		offset += idLength
-		transactionIdLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		transactionId := response[offset : offset+transactionIdLength]
-		offset += transactionIdLength
-		errorLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		errorMessage := response[offset : offset+errorLength]
-
-		responses = append(responses, QueryResponse{
-			Data: QueryResponseData{
-				Version:       version,
-				ID:            id,
-				TransactionId: transactionId,
-			},
-			Error: errorMessage,
-		})
+		queryResponse, err = decodeErrorEntry(response)
+	case QueryStreamPrepareStatement:
+		queryResponse, err = decodePrepareStatementEntry(response)
 	case QueryStreamFrameEntry:
-		responseLength := buffer.Next(4)
-		response := buffer.Next(int(binary.LittleEndian.Uint32(responseLength)))
-		version := response[0]
-		offset := 1
-		idLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		id := response[offset : offset+idLength]
-		offset += idLength
-		transactionIdLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		transactionId := response[offset : offset+transactionIdLength]
-		offset += transactionIdLength
-		changes := int64(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		latency := float64(binary.LittleEndian.Uint64(response[offset : offset+8]))
-		offset += 8
-		columnsCount := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		rowsCount := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		lastInsertRowID := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		columnsLength := int(binary.LittleEndian.Uint32(response[offset : offset+4]))
-		offset += 4
-		columnBytes := response[offset : offset+columnsLength]
-		offset += columnsLength
-		rowBytes := response[offset:]
-
-		columns := decodeColumns(columnsCount, columnBytes)
-		rows := decodeRows(rowsCount, columnsCount, rowBytes)
-
-		responses = append(responses, QueryResponse{
-			Data: QueryResponseData{
-				Version:         version,
-				Changes:         changes,
-				Latency:         latency,
-				ColumnsCount:    columnsCount,
-				RowsCount:       rowsCount,
-				LastInsertRowID: lastInsertRowID,
-				ID:              id,
-				Columns:         columns,
-				Rows:            rows,
-				TransactionId:   transactionId,
-			},
-		})
-	}
-
-	return responses
+		queryResponse, err = decodeFrameEntry(response)
+	default:
+		err = fmt.Errorf("%w: unknown entry type %#x", ErrMalformedResponse, byte(messageType))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []QueryResponse{queryResponse}, nil
+}
+
+// Decode the handle of a prepared statement: the version followed by the
+// length prefixed query id and statement handle.
+func decodePrepareStatementEntry(data []byte) (QueryResponse, error) {
+	r := &responseReader{data: data}
+
+	version, err := r.byte()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	id, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	handle, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	return QueryResponse{
+		Data: QueryResponseData{
+			Version:         version,
+			ID:              id,
+			StatementHandle: handle,
+		},
+	}, nil
 }
 
-func decodeColumns(columnCount int, columnsBytes []byte) []ColumnDefinition {
-	offset := 0
+// Decode the result of a query: the version, the length prefixed query id
+// and transaction id, the flags since version 3, the counts and the encoded
+// columns and rows.
+func decodeFrameEntry(data []byte) (QueryResponse, error) {
+	r := &responseReader{data: data}
+
+	version, err := r.byte()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	id, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	transactionId, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	// Read the flags (1 byte), sent since version 3
+	var flags byte
+
+	if version >= QueryResponseVersion3 {
+		if flags, err = r.byte(); err != nil {
+			return QueryResponse{}, err
+		}
+	}
+
+	// The changes, latency, columns count, rows count, last insert row id and
+	// columns length.
+	header, err := r.next(28)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	changes := int64(binary.LittleEndian.Uint32(header[0:4]))
+	latency := float64(binary.LittleEndian.Uint64(header[4:12]))
+	columnsCount := int(binary.LittleEndian.Uint32(header[12:16]))
+	rowsCount := int(binary.LittleEndian.Uint32(header[16:20]))
+	lastInsertRowID := int(binary.LittleEndian.Uint32(header[20:24]))
+	columnsLength := int(binary.LittleEndian.Uint32(header[24:28]))
+
+	columnBytes, err := r.next(columnsLength)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	rowBytes := r.rest()
+
+	columns, err := decodeColumns(version, columnsCount, columnBytes)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	rows, err := decodeRows(rowsCount, columnsCount, rowBytes)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	return QueryResponse{
+		Data: QueryResponseData{
+			Version:         version,
+			Flags:           flags,
+			Changes:         changes,
+			Latency:         latency,
+			ColumnsCount:    columnsCount,
+			RowsCount:       rowsCount,
+			LastInsertRowID: lastInsertRowID,
+			ID:              id,
+			Columns:         columns,
+			Rows:            rows,
+			TransactionId:   transactionId,
+		},
+	}, nil
+}
+
+func decodeColumns(version byte, columnCount int, columnsBytes []byte) ([]ColumnDefinition, error) {
+	if columnCount < 0 || columnCount > maxResponseColumns {
+		return nil, fmt.Errorf("%w: %d columns", ErrMalformedResponse, columnCount)
+	}
+
+	r := &responseReader{data: columnsBytes}
 	columns := make([]ColumnDefinition, columnCount)
 	index := 0
 
-	for offset < len(columnsBytes) {
-		// Read column name length (4 bytes)
-		columnNameLength := int(binary.LittleEndian.Uint32(columnsBytes[offset : offset+4]))
-		offset += 4
+	for r.remaining() > 0 {
+		if index == columnCount {
+			return nil, fmt.Errorf("%w: more than %d columns", ErrMalformedResponse, columnCount)
+		}
 
 		// Read column name
-		columnName := string(columnsBytes[offset : offset+columnNameLength])
-		offset += columnNameLength
+		columnName, err := r.lengthPrefixed()
+
+		if err != nil {
+			return nil, err
+		}
 
 		// Read column type (4 bytes, as int32)
-		columnType := ColumnType(int32(binary.LittleEndian.Uint32(columnsBytes[offset : offset+4])))
-		offset += 4
+		columnType, err := r.uint32()
+
+		if err != nil {
+			return nil, err
+		}
+
+		column := ColumnDefinition{
+			ColumnName: string(columnName),
+			ColumnType: ColumnType(int32(columnType)),
+		}
+
+		if version >= QueryResponseVersion2 {
+			// Read declared type
+			declaredType, err := r.lengthPrefixed()
+
+			if err != nil {
+				return nil, err
+			}
+
+			column.DeclaredType = string(declaredType)
+
+			// Read nullability (1 byte)
+			nullability, err := r.byte()
+
+			if err != nil {
+				return nil, err
+			}
 
-		columns[index] = ColumnDefinition{
-			ColumnName: columnName,
-			ColumnType: columnType,
+			column.Nullability = ColumnNullability(nullability)
 		}
+
+		columns[index] = column
 		index++
 	}
 
-	return columns
+	if index != columnCount {
+		return nil, fmt.Errorf("%w: %d of %d columns", ErrMalformedResponse, index, columnCount)
+	}
+
+	return columns, nil
 }
 
-func decodeRows(rowsCount, columnsCount int, rowsBytes []byte) [][]Column {
-	rowsOffset := 0
-	rows := make([][]Column, 0, rowsCount)
+func decodeRows(rowsCount, columnsCount int, rowsBytes []byte) ([][]Column, error) {
+	if rowsCount < 0 || columnsCount < 0 || columnsCount > maxResponseColumns {
+		return nil, fmt.Errorf("%w: %d rows of %d columns", ErrMalformedResponse, rowsCount, columnsCount)
+	}
+
+	// The count is only a hint, every row takes at least its 4 byte length.
+	rows := make([][]Column, 0, min(rowsCount, len(rowsBytes)/4))
+	r := &responseReader{data: rowsBytes}
 
-	for rowsOffset < len(rowsBytes) {
-		rowLength := int(binary.LittleEndian.Uint32(rowsBytes[rowsOffset : rowsOffset+4]))
-		rowsOffset += 4
-		rowOffset := rowsOffset
-		rowsOffset += rowLength
+	for r.remaining() > 0 {
+		rowBytes, err := r.lengthPrefixed()
+
+		if err != nil {
+			return nil, err
+		}
+
+		row := &responseReader{data: rowBytes}
+
+		// Every column takes at least its 1 byte type and 4 byte length.
+		if len(rowBytes)/5 < columnsCount {
+			return nil, fmt.Errorf("%w: row of %d bytes cannot hold %d columns", ErrMalformedResponse, len(rowBytes), columnsCount)
+		}
 
 		// Create a new row for each iteration
 		currentRow := make([]Column, columnsCount)
 		columnIndex := 0
 
-		for rowOffset < rowsOffset {
-			columnType := rowsBytes[rowOffset]
-			rowOffset++
-			columnValueLength := int(binary.LittleEndian.Uint32(rowsBytes[rowOffset : rowOffset+4]))
-			rowOffset += 4
-			columnValue := rowsBytes[rowOffset : rowOffset+columnValueLength]
-			rowOffset += columnValueLength
+		for row.remaining() > 0 {
+			if columnIndex == columnsCount {
+				return nil, fmt.Errorf("%w: row has more than %d columns", ErrMalformedResponse, columnsCount)
+			}
+
+			columnType, err := row.byte()
+
+			if err != nil {
+				return nil, err
+			}
+
+			columnValue, err := row.lengthPrefixed()
 
+			if err != nil {
+				return nil, err
+			}
+
+			// Values are decoded into Go types when the row is read, see
+			// decodeColumnValue.
 			var column Column
 
 			column.Type = ColumnType(columnType)
 			column.Value = columnValue
 
-			// switch ColumnType(columnType) {
-			// case ColumnTypeInteger:
-			// 	columnValue
-			// 	value = int64(binary.LittleEndian.Uint64(columnValue))
-			// case ColumnTypeFloat:
-			// 	value = float64(binary.LittleEndian.Uint64(columnValue))
-			// case ColumnTypeText:
-			// 	value = columnValue
-			// case ColumnTypeBlob:
-			// 	value = columnValue
-			// case ColumnTypeNull:
-			// 	value = nil
-			// }
-
 			currentRow[columnIndex] = column
 			columnIndex++
 		}
 
+		if columnIndex != columnsCount {
+			return nil, fmt.Errorf("%w: row has %d of %d columns", ErrMalformedResponse, columnIndex, columnsCount)
+		}
+
 		rows = append(rows, currentRow)
 	}
 
-	return rows
+	return rows, nil
+}
+
+// Decode the error of a query: the version followed by the length prefixed
+// query id and transaction id, the extended result code since version 4 and
+// the length prefixed error message.
+func decodeErrorEntry(data []byte) (QueryResponse, error) {
+	r := &responseReader{data: data}
+
+	version, err := r.byte()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	if version < QueryResponseVersion1 || version > QueryResponseVersion4 {
+		return QueryResponse{}, fmt.Errorf("%w: unknown error entry version %d", ErrMalformedResponse, version)
+	}
+
+	id, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	transactionID, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	var code uint32
+
+	if version >= QueryResponseVersion4 {
+		if code, err = r.uint32(); err != nil {
+			return QueryResponse{}, err
+		}
+	}
+
+	message, err := r.lengthPrefixed()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	if r.remaining() > 0 {
+		return QueryResponse{}, fmt.Errorf("%w: %d bytes after the error entry", ErrMalformedResponse, r.remaining())
+	}
+
+	return QueryResponse{
+		Data: QueryResponseData{
+			Version:       version,
+			ID:            id,
+			TransactionId: transactionID,
+		},
+		Error:     message,
+		ErrorCode: ErrorCode(int32(code)),
+	}, nil
+}
+
+// Reads the fields of an encoded entry, returning an error instead of reading
+// past its end.
+type responseReader struct {
+	data   []byte
+	offset int
+}
+
+// Get the number of bytes left to read.
+func (r *responseReader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+// Read the next n bytes.
+func (r *responseReader) next(n int) ([]byte, error) {
+	if n < 0 || n > r.remaining() {
+		return nil, fmt.Errorf("%w: %d bytes needed at offset %d, %d left", ErrMalformedResponse, n, r.offset, r.remaining())
+	}
+
+	value := r.data[r.offset : r.offset+n]
+	r.offset += n
+
+	return value, nil
+}
+
+// Read the bytes left.
+func (r *responseReader) rest() []byte {
+	value := r.data[r.offset:]
+	r.offset = len(r.data)
+
+	return value
+}
+
+func (r *responseReader) byte() (byte, error) {
+	value, err := r.next(1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return value[0], nil
+}
+
+func (r *responseReader) uint32() (uint32, error) {
+	value, err := r.next(4)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+// Read a value prefixed with its 4 byte length.
+func (r *responseReader) lengthPrefixed() ([]byte, error) {
+	length, err := r.uint32()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.next(int(length))
 }
@@ -0,0 +1,173 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func appendLengthPrefixed(b []byte, value string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+
+	return append(b, value...)
+}
+
+func encodeTestColumns() []byte {
+	var columns []byte
+
+	for _, name := range []string{"id", "name"} {
+		columns = appendLengthPrefixed(columns, name)
+		columns = binary.LittleEndian.AppendUint32(columns, uint32(ColumnTypeText))
+		columns = appendLengthPrefixed(columns, "TEXT")
+		columns = append(columns, 1)
+	}
+
+	return columns
+}
+
+func encodeTestRows() []byte {
+	var rows []byte
+
+	for _, values := range [][]string{{"1", "a"}, {"2", "b"}} {
+		var row []byte
+
+		for _, value := range values {
+			row = append(row, byte(ColumnTypeText))
+			row = appendLengthPrefixed(row, value)
+		}
+
+		rows = appendLengthPrefixed(rows, string(row))
+	}
+
+	return rows
+}
+
+func encodeTestEntry(messageType QueryStreamMessageType, data []byte) []byte {
+	entry := []byte{byte(messageType)}
+	entry = binary.LittleEndian.AppendUint32(entry, uint32(len(data)))
+
+	return append(entry, data...)
+}
+
+func encodeTestFrameEntry(id string, flags byte) []byte {
+	columns := encodeTestColumns()
+
+	data := []byte{QueryResponseVersion3}
+	data = appendLengthPrefixed(data, id)
+	data = appendLengthPrefixed(data, "")
+	data = append(data, flags)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(columns)))
+	data = append(data, columns...)
+	data = append(data, encodeTestRows()...)
+
+	return encodeTestEntry(QueryStreamFrameEntry, data)
+}
+
+func TestQueryResponseDecoder(t *testing.T) {
+	entry := encodeTestFrameEntry("query", 0)
+
+	responses, err := QueryResponseDecoder(bytes.NewBuffer(entry))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := responses[0].Data
+
+	if string(data.ID) != "query" || len(data.Columns) != 2 || len(data.Rows) != 2 || string(data.Rows[1][1].Value) != "b" {
+		t.Errorf("unexpected response: %+v", data)
+	}
+
+	// Every truncation of the entry is reported instead of read past
+	for i := 0; i < len(entry); i++ {
+		if _, err := QueryResponseDecoder(bytes.NewBuffer(entry[:i])); !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("expected %v for %d bytes, got %v", ErrMalformedResponse, i, err)
+		}
+	}
+}
+
+func FuzzQueryResponseDecoder(f *testing.F) {
+	prepare := []byte{QueryResponseVersion1}
+	prepare = appendLengthPrefixed(prepare, "query")
+	prepare = appendLengthPrefixed(prepare, "handle")
+
+	queryError := []byte{QueryResponseVersion4}
+	queryError = appendLengthPrefixed(queryError, "query")
+	queryError = appendLengthPrefixed(queryError, "")
+	queryError = binary.LittleEndian.AppendUint32(queryError, uint32(ErrorCodeConstraintUnique))
+	queryError = appendLengthPrefixed(queryError, "UNIQUE constraint failed")
+
+	f.Add(encodeTestFrameEntry("query", 0))
+	f.Add(encodeTestEntry(QueryStreamPrepareStatement, prepare))
+	f.Add(encodeTestEntry(QueryStreamError, queryError))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buffer := bytes.NewBuffer(data)
+
+		for buffer.Len() > 0 {
+			responses, err := QueryResponseDecoder(buffer)
+
+			if err != nil {
+				if !errors.Is(err, ErrMalformedResponse) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if len(responses) != 1 {
+				t.Fatalf("expected 1 response, got %d", len(responses))
+			}
+		}
+	})
+}
+
+func FuzzDecodeColumns(f *testing.F) {
+	f.Add(QueryResponseVersion2, 2, encodeTestColumns())
+	f.Add(QueryResponseVersion1, 1, []byte{2, 0, 0, 0, 'i', 'd', 3, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, version byte, columnsCount int, data []byte) {
+		columns, err := decodeColumns(version, columnsCount, data)
+
+		if err == nil && len(columns) != columnsCount {
+			t.Fatalf("expected %d columns, got %d", columnsCount, len(columns))
+		}
+	})
+}
+
+func FuzzDecodeRows(f *testing.F) {
+	f.Add(2, 2, encodeTestRows())
+	f.Add(0, 0, []byte{})
+
+	f.Fuzz(func(t *testing.T, rowsCount, columnsCount int, data []byte) {
+		rows, err := decodeRows(rowsCount, columnsCount, data)
+
+		if err != nil {
+			return
+		}
+
+		for _, row := range rows {
+			if len(row) != columnsCount {
+				t.Fatalf("expected %d columns, got %d", columnsCount, len(row))
+			}
+		}
+	})
+}
+
+func TestDecodeColumnsCount(t *testing.T) {
+	// Fewer column definitions than columns
+	if _, err := decodeColumns(QueryResponseVersion2, 3, encodeTestColumns()); !errors.Is(err, ErrMalformedResponse) {
+		t.Errorf("expected %v, got %v", ErrMalformedResponse, err)
+	}
+
+	// More column definitions than columns
+	if _, err := decodeColumns(QueryResponseVersion2, 1, encodeTestColumns()); !errors.Is(err, ErrMalformedResponse) {
+		t.Errorf("expected %v, got %v", ErrMalformedResponse, err)
+	}
+}
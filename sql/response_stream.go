@@ -0,0 +1,180 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// The number of responses buffered for a stream. Responses that arrive while
+// the buffer is full are queued until the reader of the stream catches up.
+const ResponseStreamBufferSize = 8
+
+// The number of responses queued for a stream on top of its buffer. A query
+// whose reader falls further behind fails with ErrReaderTooSlow, since the
+// server cannot be paused for a single query of the stream.
+const ResponseStreamMaxQueued = 64
+
+// ResponseStream reads the responses of a query as they arrive. Large results
+// arrive as several responses with the rows split between them, and a query
+// that runs several statements sends a response for each result set.
+//
+// When the stream reads from a cursor the server only sends the next response
+// once the stream asks for it.
+type ResponseStream struct {
+	connection   *Connection
+	ctx          context.Context
+	done         bool
+	fetchSize    int
+	finished     bool
+	id           string
+	onClose      func()
+	pendingQuery *PendingQuery
+	received     int
+}
+
+func NewResponseStream(ctx context.Context, connection *Connection, id string, pendingQuery *PendingQuery) *ResponseStream {
+	return &ResponseStream{
+		connection:   connection,
+		ctx:          ctx,
+		id:           id,
+		pendingQuery: pendingQuery,
+	}
+}
+
+// Stop reading responses. When the server has not sent the last response
+// yet it is told to stop sending.
+func (s *ResponseStream) Close() error {
+	if s.done {
+		return nil
+	}
+
+	s.done = true
+
+	// A query that was still queued has nothing to cancel on the server.
+	if s.connection.removePendingQuery(s.id) {
+		s.finished = true
+	}
+
+	s.pendingQuery.Close()
+
+	select {
+	case <-s.pendingQuery.failed:
+		// The stream the query was sent over is gone, there is nothing to
+		// cancel.
+		s.finished = true
+	default:
+	}
+
+	if !s.finished {
+		if s.fetchSize > 0 {
+			s.connection.closeCursor(s.id)
+		} else {
+			s.connection.cancelQuery(s.id)
+		}
+	}
+
+	if s.onClose != nil {
+		s.onClose()
+	}
+
+	return nil
+}
+
+// Read every response of the stream into a single response. The first
+// result set becomes the data of the response and the ones after it are
+// collected as the following result sets, rows sent in several responses are
+// joined together.
+func (s *ResponseStream) Collect() (QueryResponse, error) {
+	var response QueryResponse
+
+	resultSet := &response.Data
+
+	for received := 0; ; received++ {
+		next, err := s.Next()
+
+		if err == io.EOF {
+			return response, nil
+		}
+
+		if err != nil {
+			return QueryResponse{}, err
+		}
+
+		switch {
+		case received == 0:
+			response = next
+			resultSet = &response.Data
+		case len(next.Error) > 0:
+			response.Error = next.Error
+			response.ErrorCode = next.ErrorCode
+		case resultSet.HasMoreRows():
+			resultSet.Rows = append(resultSet.Rows, next.Data.Rows...)
+			resultSet.RowsCount += next.Data.RowsCount
+			resultSet.Changes += next.Data.Changes
+			resultSet.Flags = next.Data.Flags
+		default:
+			response.ResultSets = append(response.ResultSets, next.Data)
+			resultSet = &response.ResultSets[len(response.ResultSets)-1]
+		}
+	}
+}
+
+// Wait for the next response. Returns io.EOF after the last response.
+func (s *ResponseStream) Next() (QueryResponse, error) {
+	if s.finished {
+		return QueryResponse{}, io.EOF
+	}
+
+	if s.done {
+		return QueryResponse{}, fmt.Errorf("response stream is closed")
+	}
+
+	// The first response of a cursor is sent when it is opened.
+	if s.fetchSize > 0 && s.received > 0 {
+		s.connection.fetchCursor(s.id, s.fetchSize)
+	}
+
+	// Responses that arrived before the query failed are read first.
+	select {
+	case response := <-s.pendingQuery.responses:
+		return s.receive(response), nil
+	default:
+	}
+
+	select {
+	case response := <-s.pendingQuery.responses:
+		return s.receive(response), nil
+	case <-s.pendingQuery.failed:
+		s.finished = true
+
+		return QueryResponse{}, s.pendingQuery.err
+	case <-s.ctx.Done():
+		s.Close()
+
+		return QueryResponse{}, s.ctx.Err()
+	case <-s.connection.ctx.Done():
+		// Closing the connection fails its pending queries first.
+		select {
+		case <-s.pendingQuery.failed:
+			s.finished = true
+
+			return QueryResponse{}, s.pendingQuery.err
+		default:
+		}
+
+		return QueryResponse{}, fmt.Errorf("connection closed while waiting for response %s", s.id)
+	}
+}
+
+// Count a received response and note when it is the last one.
+func (s *ResponseStream) receive(response QueryResponse) QueryResponse {
+	s.received++
+	s.pendingQuery.refill()
+
+	if len(response.Error) > 0 || (!response.Data.HasMoreRows() && !response.Data.HasMoreResultSets()) {
+		s.finished = true
+	}
+
+	return response
+}
@@ -0,0 +1,153 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Create a stream that reads the given responses as if the connection had
+// received them.
+func newTestResponseStream(t *testing.T, responses ...QueryResponse) *ResponseStream {
+	t.Helper()
+
+	c := newTestConnection(t)
+
+	pendingQuery := NewPendingQuery(len(responses))
+	pendingQuery.written = true
+
+	for _, response := range responses {
+		pendingQuery.responses <- response
+	}
+
+	c.responses["query"] = pendingQuery
+
+	return NewResponseStream(context.Background(), c, "query", pendingQuery)
+}
+
+// A response with a text column for each of the given values.
+func testResponse(flags byte, values ...string) QueryResponse {
+	rows := make([][]Column, len(values))
+
+	for i, value := range values {
+		rows[i] = []Column{{Type: ColumnTypeText, Value: []byte(value)}}
+	}
+
+	return QueryResponse{
+		Data: QueryResponseData{
+			Flags:     flags,
+			Columns:   []ColumnDefinition{{ColumnName: "value", ColumnType: ColumnTypeText}},
+			RowsCount: len(rows),
+			Rows:      rows,
+		},
+	}
+}
+
+func TestResponseStreamCollect(t *testing.T) {
+	stream := newTestResponseStream(
+		t,
+		testResponse(QueryResponseFlagMoreRows, "a", "b"),
+		testResponse(QueryResponseFlagMoreResultSets, "c"),
+		testResponse(QueryResponseFlagMoreRows, "d"),
+		testResponse(0, "e"),
+	)
+
+	response, err := stream.Collect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows sent in several responses are joined
+	if response.Data.RowsCount != 3 || !reflect.DeepEqual(response.Data.Rows, testResponse(0, "a", "b", "c").Data.Rows) {
+		t.Errorf("unexpected first result set: %+v", response.Data)
+	}
+
+	if len(response.ResultSets) != 1 {
+		t.Fatalf("expected 1 more result set, got %d", len(response.ResultSets))
+	}
+
+	if resultSet := response.ResultSets[0]; resultSet.RowsCount != 2 || !reflect.DeepEqual(resultSet.Rows, testResponse(0, "d", "e").Data.Rows) {
+		t.Errorf("unexpected second result set: %+v", resultSet)
+	}
+
+	if _, err := stream.Next(); err != io.EOF {
+		t.Errorf("expected %v after the last response, got %v", io.EOF, err)
+	}
+}
+
+func TestResponseStreamCollectError(t *testing.T) {
+	stream := newTestResponseStream(
+		t,
+		testResponse(QueryResponseFlagMoreRows, "a"),
+		QueryResponse{Error: []byte("interrupted"), ErrorCode: 9},
+	)
+
+	response, err := stream.Collect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An error after the first response ends the whole query
+	if string(response.Error) != "interrupted" || response.ErrorCode != 9 {
+		t.Errorf("expected the error of the last response, got %q (%d)", response.Error, response.ErrorCode)
+	}
+
+	// The stream failing is returned as it is
+	failed := errors.New("stream failed")
+	stream = newTestResponseStream(t, testResponse(QueryResponseFlagMoreRows, "a"))
+	stream.pendingQuery.fail(failed)
+
+	if _, err := stream.Collect(); err != failed {
+		t.Errorf("expected %v, got %v", failed, err)
+	}
+}
+
+func TestResponseStreamCancel(t *testing.T) {
+	c := newTestConnection(t)
+
+	logs := &bytes.Buffer{}
+	c.config.Logger = log.New(logs, "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.Stream(ctx, Query{ID: "query", Statement: "SELECT 1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nextTestFrame(t, c)
+
+	cancel()
+
+	if _, err := stream.Next(); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// The server is told to stop working on the query
+	frame := nextTestFrame(t, c)
+
+	if frame.messageType != QueryStreamCancelQuery || !bytes.Equal(frame.payload, appendLengthPrefixed(nil, "query")) {
+		t.Errorf("expected the query to be cancelled, got %v %q", frame.messageType, frame.payload)
+	}
+
+	// A response sent before the server saw the cancellation is dropped
+	// quietly, unlike one nobody asked for
+	for _, id := range []string{"query", "unknown"} {
+		entry := encodeTestEntry(QueryStreamFrame, encodeTestFrameEntry(id, 0))
+
+		if err := c.readResponses(bytes.NewReader(entry), make(chan struct{})); err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+
+	if logged := logs.String(); strings.Contains(logged, "query") || !strings.Contains(logged, "No response channel for id: unknown") {
+		t.Errorf("expected only the unknown response to be logged, got %q", logged)
+	}
+}
@@ -16,6 +16,26 @@ func NewResult(columns []ColumnDefinition, changes, lastInsertId int64, rows [][
 	}
 }
 
+// Create a result from a response. When the query ran several statements
+// the changes of every statement are added up and the last insert id is the
+// one of the last statement.
+func NewResultFromResponse(response QueryResponse) *Result {
+	changes := response.Data.Changes
+	lastInsertId := int64(response.Data.LastInsertRowID)
+
+	for _, resultSet := range response.ResultSets {
+		changes += resultSet.Changes
+		lastInsertId = int64(resultSet.LastInsertRowID)
+	}
+
+	return NewResult(
+		response.Data.Columns,
+		changes,
+		lastInsertId,
+		response.Data.Rows,
+	)
+}
+
 func (r *Result) LastInsertId() (int64, error) {
 	return r.lastInsertId, nil
 }
@@ -2,50 +2,220 @@ package sql
 
 import (
 	"database/sql/driver"
-	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// Rows reads the rows of a result. Rows of a streamed result are read as the
+// server sends them, so only the responses buffered and queued by the stream
+// are held in memory. A reader that falls too far behind gets
+// ErrReaderTooSlow, a cursor only holds one batch of rows at a time.
 type Rows struct {
-	columns     []string
-	columnDefs  []ColumnDefinition
-	index       int
-	rows        [][]Column
+	closed     bool
+	columns    []string
+	columnDefs []ColumnDefinition
+	err        error
+	flags      byte
+	index      int
+	release    func()
+	rows       [][]Column
+	stream     *ResponseStream
 }
 
 func NewRows(columnData []ColumnDefinition, rows [][]Column) *Rows {
+	r := &Rows{}
+
+	r.setResultSet(columnData, rows, 0)
+
+	return r
+}
+
+// Create rows that read a result from a response stream, starting with the
+// first response of the stream. The release function is called when the rows
+// are closed.
+func NewStreamingRows(response QueryResponse, stream *ResponseStream, release func()) *Rows {
+	r := NewRows(response.Data.Columns, response.Data.Rows)
+
+	r.flags = response.Data.Flags
+	r.release = release
+	r.stream = stream
+
+	return r
+}
+
+func (r *Rows) setResultSet(columnData []ColumnDefinition, rows [][]Column, flags byte) {
 	columns := make([]string, len(columnData))
 
 	for i, column := range columnData {
 		columns[i] = column.ColumnName
 	}
 
-	return &Rows{
-		columns:    columns,
-		columnDefs: columnData,
-		index:      -1,
-		rows:       rows,
-	}
+	r.columns = columns
+	r.columnDefs = columnData
+	r.flags = flags
+	r.index = -1
+	r.rows = rows
 }
 
 func (r *Rows) Columns() []string {
 	return r.columns
 }
 
+// Close the rows. The server is told to stop sending rows when the result was
+// not read to the end.
 func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	if r.stream != nil {
+		r.stream.Close()
+	}
+
+	if r.release != nil {
+		r.release()
+	}
 
 	return nil
 }
 
+// Read the next response with rows of the current result set.
+func (r *Rows) fetch() error {
+	response, err := r.stream.Next()
+
+	if err != nil {
+		return err
+	}
+
+	if len(response.Error) > 0 {
+		return response.err()
+	}
+
+	r.flags = response.Data.Flags
+	r.index = -1
+	r.rows = response.Data.Rows
+
+	return nil
+}
+
+func (r *Rows) HasNextResultSet() bool {
+	if r.err != nil {
+		return false
+	}
+
+	// Skip the rows of the current result set that were not read, the flags
+	// of its last response tell if another result set follows.
+	for r.flags&QueryResponseFlagMoreRows != 0 {
+		if err := r.fetch(); err != nil {
+			// Returned by NextResultSet, which has no other way to get it.
+			r.err = err
+
+			return false
+		}
+	}
+
+	return r.flags&QueryResponseFlagMoreResultSets != 0
+}
+
 func (r *Rows) Next(dest []driver.Value) error {
-	if r.index >= len(r.rows)-1 {
-		return errors.New("no more rows")
+	for r.index >= len(r.rows)-1 {
+		if r.flags&QueryResponseFlagMoreRows == 0 {
+			return io.EOF
+		}
+
+		if err := r.fetch(); err != nil {
+			return err
+		}
 	}
 
 	r.index++
 
 	for i, column := range r.rows[r.index] {
-		dest[i] = column.Value
+		value, err := decodeColumnValue(column)
+
+		if err != nil {
+			return err
+		}
+
+		dest[i] = value
+	}
+
+	return nil
+}
+
+// Move to the next result set, each result set has its own columns.
+func (r *Rows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		if r.err != nil {
+			return r.err
+		}
+
+		return io.EOF
+	}
+
+	response, err := r.stream.Next()
+
+	if err != nil {
+		return err
+	}
+
+	if len(response.Error) > 0 {
+		return response.err()
 	}
 
+	r.setResultSet(response.Data.Columns, response.Data.Rows, response.Data.Flags)
+
 	return nil
 }
+
+// The declared type of the column without its length, such as VARCHAR for a
+// column declared as VARCHAR(32). The storage class is used when the column
+// has no declared type, for example when it is an expression.
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	column := r.columnDefs[index]
+
+	if column.DeclaredType == "" {
+		return column.ColumnType.String()
+	}
+
+	name, _, _ := strings.Cut(column.DeclaredType, "(")
+
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+// The length of a column declared with one, such as VARCHAR(32). SQLite does
+// not enforce it, so it is only reported for reference.
+func (r *Rows) ColumnTypeLength(index int) (int64, bool) {
+	_, arguments, ok := strings.Cut(r.columnDefs[index].DeclaredType, "(")
+
+	if !ok {
+		return 0, false
+	}
+
+	length, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(arguments, ")")), 10, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return length, true
+}
+
+func (r *Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	switch r.columnDefs[index].Nullability {
+	case ColumnNullable:
+		return true, true
+	case ColumnNotNull:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columnDefs[index].ColumnType.ScanType()
+}
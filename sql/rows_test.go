@@ -0,0 +1,144 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Read the values of the current result set.
+func readRows(t *testing.T, rows *Rows) []string {
+	t.Helper()
+
+	values := []string{}
+	dest := make([]driver.Value, 1)
+
+	for {
+		err := rows.Next(dest)
+
+		if err == io.EOF {
+			return values
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values = append(values, dest[0].(string))
+	}
+}
+
+func TestRowsStreaming(t *testing.T) {
+	first := testResponse(QueryResponseFlagMoreRows, "a")
+
+	stream := newTestResponseStream(
+		t,
+		testResponse(QueryResponseFlagMoreResultSets, "b", "c"),
+		testResponse(QueryResponseFlagMoreRows, "d"),
+		testResponse(QueryResponseFlagMoreResultSets, "e"),
+		testResponse(0, "f"),
+	)
+
+	released := false
+	rows := NewStreamingRows(first, stream, func() { released = true })
+
+	if values := readRows(t, rows); len(values) != 3 || values[0] != "a" || values[2] != "c" {
+		t.Errorf("unexpected rows of the first result set: %v", values)
+	}
+
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows that were not read are skipped
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if values := readRows(t, rows); len(values) != 1 || values[0] != "f" {
+		t.Errorf("unexpected rows of the last result set: %v", values)
+	}
+
+	if err := rows.NextResultSet(); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+
+	rows.Close()
+
+	if !released {
+		t.Error("expected the rows to be released when closed")
+	}
+}
+
+func TestRowsNextResultSetError(t *testing.T) {
+	first := testResponse(QueryResponseFlagMoreRows, "a")
+	stream := newTestResponseStream(t, QueryResponse{Error: []byte("interrupted"), ErrorCode: 9})
+	rows := NewStreamingRows(first, stream, nil)
+
+	// The error while skipping the rows is not lost
+	if rows.HasNextResultSet() {
+		t.Fatal("expected no next result set")
+	}
+
+	var e *Error
+
+	if err := rows.NextResultSet(); !errors.As(err, &e) || e.Message != "interrupted" {
+		t.Errorf("expected the error of the response, got %v", err)
+	}
+}
+
+func TestRowsColumnTypes(t *testing.T) {
+	tests := []struct {
+		column   ColumnDefinition
+		name     string
+		length   int64
+		ok       bool
+		scanType reflect.Type
+	}{
+		{ColumnDefinition{ColumnType: ColumnTypeText, DeclaredType: "VARCHAR(32)"}, "VARCHAR", 32, true, reflect.TypeOf("")},
+		{ColumnDefinition{ColumnType: ColumnTypeText, DeclaredType: "varchar ( 32 )"}, "VARCHAR", 32, true, reflect.TypeOf("")},
+		{ColumnDefinition{ColumnType: ColumnTypeInteger, DeclaredType: "INTEGER"}, "INTEGER", 0, false, reflect.TypeOf(int64(0))},
+		{ColumnDefinition{ColumnType: ColumnTypeFloat, DeclaredType: "DECIMAL(10,2)"}, "DECIMAL", 0, false, reflect.TypeOf(float64(0))},
+		{ColumnDefinition{ColumnType: ColumnTypeFloat}, "REAL", 0, false, reflect.TypeOf(float64(0))},
+		{ColumnDefinition{ColumnType: ColumnTypeBlob}, "BLOB", 0, false, reflect.TypeOf([]byte(nil))},
+		{ColumnDefinition{ColumnType: ColumnTypeNull}, "NULL", 0, false, reflect.TypeOf((*any)(nil)).Elem()},
+	}
+
+	for _, test := range tests {
+		rows := NewRows([]ColumnDefinition{test.column}, nil)
+
+		if name := rows.ColumnTypeDatabaseTypeName(0); name != test.name {
+			t.Errorf("%+v: expected the type name %q, got %q", test.column, test.name, name)
+		}
+
+		if length, ok := rows.ColumnTypeLength(0); length != test.length || ok != test.ok {
+			t.Errorf("%+v: expected the length %d, %v, got %d, %v", test.column, test.length, test.ok, length, ok)
+		}
+
+		if scanType := rows.ColumnTypeScanType(0); scanType != test.scanType {
+			t.Errorf("%+v: expected the scan type %v, got %v", test.column, test.scanType, scanType)
+		}
+	}
+}
+
+func TestRowsColumnTypeNullable(t *testing.T) {
+	tests := []struct {
+		nullability ColumnNullability
+		nullable    bool
+		ok          bool
+	}{
+		{ColumnNullabilityUnknown, false, false},
+		{ColumnNullable, true, true},
+		{ColumnNotNull, false, true},
+	}
+
+	for _, test := range tests {
+		rows := NewRows([]ColumnDefinition{{ColumnType: ColumnTypeText, Nullability: test.nullability}}, nil)
+
+		if nullable, ok := rows.ColumnTypeNullable(0); nullable != test.nullable || ok != test.ok {
+			t.Errorf("%d: expected %v, %v, got %v, %v", test.nullability, test.nullable, test.ok, nullable, ok)
+		}
+	}
+}
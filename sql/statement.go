@@ -1,24 +1,36 @@
 package sql
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
-	"regexp"
 
 	"github.com/google/uuid"
 )
 
 type Statement struct {
-	closed bool
-	pool   *ConnectionPool
-	SQL    string
+	bindParameters *bindParameters
+	closed         bool
+	conn           *Conn
+	SQL            string
 }
 
-func NewStatement(pool *ConnectionPool, sql string) *Statement {
-	return &Statement{
-		pool: pool,
-		SQL:  sql,
+func NewStatement(conn *Conn, sql string) (*Statement, error) {
+	bindParameters, err := parseBindParameters(sql)
+
+	if err != nil {
+		return nil, err
 	}
+
+	return &Statement{
+		bindParameters: bindParameters,
+		conn:           conn,
+		SQL:            sql,
+	}, nil
+}
+
+func (s *Statement) CheckNamedValue(value *driver.NamedValue) error {
+	return s.conn.CheckNamedValue(value)
 }
 
 func (s *Statement) Close() error {
@@ -31,25 +43,23 @@ func (s *Statement) Close() error {
 	return nil
 }
 
+// Deprecated: Use ExecContext instead.
 func (s *Statement) Exec(args []driver.Value) (driver.Result, error) {
-	connection, err := s.pool.Get()
-
-	if err != nil {
-		return nil, err
-	}
-
-	defer s.pool.Put(connection)
+	return s.ExecContext(context.Background(), namedValues(args))
+}
 
-	parameters, err := prepareParameters(args)
+func (s *Statement) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	parameters, err := s.bindParameters.prepare(args)
 
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := connection.Send(Query{
+	response, err := s.conn.send(ctx, Query{
 		ID:         uuid.NewString(),
 		Statement:  s.SQL,
 		Parameters: parameters,
+		Prepare:    true,
 	})
 
 	if err != nil {
@@ -57,54 +67,32 @@ func (s *Statement) Exec(args []driver.Value) (driver.Result, error) {
 	}
 
 	if len(response.Error) > 0 {
-		return nil, errors.New(string(response.Error))
+		return nil, response.err()
 	}
 
-	return NewResult(
-		response.Data.Columns,
-		int64(response.Data.Changes),
-		int64(response.Data.LastInsertRowID),
-		response.Data.Rows,
-	), nil
+	return NewResultFromResponse(response), nil
 }
 
 func (s *Statement) NumInput() int {
-	count := 0
-	paramRegex := regexp.MustCompile(`[?]\d+|[:@$]\w+`)
-	params := paramRegex.FindAllString(s.SQL, -1)
-	count += len(params)
-
-	return count
+	return s.bindParameters.count
 }
 
+// Deprecated: Use QueryContext instead.
 func (s *Statement) Query(args []driver.Value) (driver.Rows, error) {
-	connection, err := s.pool.Get()
-
-	if err != nil {
-		return nil, err
-	}
-
-	defer s.pool.Put(connection)
+	return s.QueryContext(context.Background(), namedValues(args))
+}
 
-	parameters, err := prepareParameters(args)
+func (s *Statement) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	parameters, err := s.bindParameters.prepare(args)
 
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := connection.Send(Query{
+	return s.conn.query(ctx, Query{
 		ID:         uuid.NewString(),
 		Statement:  s.SQL,
 		Parameters: parameters,
+		Prepare:    true,
 	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(response.Error) > 0 {
-		return nil, errors.New(string(response.Error))
-	}
-
-	return NewRows(response.Data.Columns, response.Data.Rows), nil
 }
@@ -0,0 +1,154 @@
+package sql
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The number of prepared statement handles each connection keeps.
+const DefaultStatementCacheSize = 100
+
+// StatementCache is a least recently used cache of the prepared statement
+// handles the server returned for the SQL of a statement. Handles that are
+// evicted while a query is still using them are only closed once the last
+// query releases them.
+type StatementCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	epoch    int
+	list     *list.List
+	mutex    *sync.Mutex
+}
+
+type CachedStatement struct {
+	evicted     bool
+	handle      string
+	invalidated bool
+	references  int
+	sql         string
+}
+
+func NewStatementCache(capacity int) *StatementCache {
+	return &StatementCache{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		list:     list.New(),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Get the cached statement for the SQL and hold a reference to it until it is
+// released.
+func (s *StatementCache) Acquire(sql string) (*CachedStatement, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.entries[sql]
+
+	if !ok {
+		return nil, false
+	}
+
+	s.list.MoveToFront(element)
+
+	statement := element.Value.(*CachedStatement)
+	statement.references++
+
+	return statement, true
+}
+
+// Get the epoch of the cache, it changes every time the cache is invalidated.
+func (s *StatementCache) Epoch() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.epoch
+}
+
+// Add a handle for the SQL and hold a reference to it until it is released.
+// When the SQL was prepared concurrently the handle already in the cache is
+// returned instead. The handles returned for closing are no longer used and
+// should be closed on the server.
+//
+// The epoch is the one the handle was prepared in. The handle is refused
+// when the cache was invalidated since, as it no longer exists on the server.
+func (s *StatementCache) Add(sql, handle string, epoch int) (*CachedStatement, []string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if epoch != s.epoch {
+		return nil, nil, false
+	}
+
+	if element, ok := s.entries[sql]; ok {
+		s.list.MoveToFront(element)
+
+		statement := element.Value.(*CachedStatement)
+		statement.references++
+
+		return statement, []string{handle}, true
+	}
+
+	statement := &CachedStatement{
+		handle:     handle,
+		references: 1,
+		sql:        sql,
+	}
+
+	s.entries[sql] = s.list.PushFront(statement)
+
+	closable := []string{}
+
+	for s.list.Len() > s.capacity {
+		element := s.list.Back()
+		evicted := element.Value.(*CachedStatement)
+
+		s.list.Remove(element)
+		delete(s.entries, evicted.sql)
+
+		evicted.evicted = true
+
+		if evicted.references == 0 {
+			closable = append(closable, evicted.handle)
+		}
+	}
+
+	return statement, closable, true
+}
+
+// Release a reference to a statement. Returns true when the statement was
+// evicted and this was the last reference, so its handle should be closed.
+func (s *StatementCache) Release(statement *CachedStatement) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statement.references--
+
+	return statement.evicted && !statement.invalidated && statement.references == 0
+}
+
+// Report whether a statement handle still exists on the server, it does not
+// after the cache was invalidated.
+func (s *StatementCache) valid(statement *CachedStatement) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return !statement.invalidated
+}
+
+// Remove every statement from the cache without closing the handles, for
+// when the handles no longer exist on the server such as after a reconnect.
+func (s *StatementCache) Invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for element := s.list.Front(); element != nil; element = element.Next() {
+		statement := element.Value.(*CachedStatement)
+		statement.evicted = true
+		statement.invalidated = true
+	}
+
+	s.entries = map[string]*list.Element{}
+	s.epoch++
+	s.list.Init()
+}
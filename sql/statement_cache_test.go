@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatementCacheEviction(t *testing.T) {
+	cache := NewStatementCache(2)
+
+	a, _, _ := cache.Add("a", "handle-a", cache.Epoch())
+	cache.Release(a)
+
+	b, _, _ := cache.Add("b", "handle-b", cache.Epoch())
+	cache.Release(b)
+
+	// Using a makes b the least recently used statement
+	a, ok := cache.Acquire("a")
+
+	if !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	cache.Release(a)
+
+	_, closable, _ := cache.Add("c", "handle-c", cache.Epoch())
+
+	if !reflect.DeepEqual(closable, []string{"handle-b"}) {
+		t.Errorf("expected handle-b to be closed, got %v", closable)
+	}
+
+	if _, ok := cache.Acquire("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	// Preparing the same SQL twice keeps the first handle
+	statement, closable, _ := cache.Add("c", "handle-c2", cache.Epoch())
+
+	if statement.handle != "handle-c" || !reflect.DeepEqual(closable, []string{"handle-c2"}) {
+		t.Errorf("expected handle-c to be kept and handle-c2 closed, got %s and %v", statement.handle, closable)
+	}
+}
+
+func TestStatementCacheRelease(t *testing.T) {
+	cache := NewStatementCache(1)
+
+	a, _, _ := cache.Add("a", "handle-a", cache.Epoch())
+	acquired, _ := cache.Acquire("a")
+
+	// a is still in use when it is evicted
+	_, closable, _ := cache.Add("b", "handle-b", cache.Epoch())
+
+	if len(closable) != 0 {
+		t.Errorf("expected no handle to be closed while a is in use, got %v", closable)
+	}
+
+	if cache.Release(a) {
+		t.Error("expected handle-a to stay open while it is in use")
+	}
+
+	if !cache.Release(acquired) {
+		t.Error("expected handle-a to be closed with the last release")
+	}
+}
+
+func TestStatementCacheInvalidate(t *testing.T) {
+	cache := NewStatementCache(2)
+
+	epoch := cache.Epoch()
+	a, _, _ := cache.Add("a", "handle-a", epoch)
+
+	cache.Invalidate()
+
+	if cache.valid(a) {
+		t.Error("expected a to be invalid")
+	}
+
+	if _, ok := cache.Acquire("a"); ok {
+		t.Error("expected a to be removed")
+	}
+
+	// The handle no longer exists on the server, there is nothing to close
+	if cache.Release(a) {
+		t.Error("expected handle-a not to be closed")
+	}
+
+	// A handle prepared before the cache was invalidated is refused
+	if _, _, ok := cache.Add("b", "handle-b", epoch); ok {
+		t.Error("expected handle-b to be refused")
+	}
+
+	if _, ok := cache.Acquire("b"); ok {
+		t.Error("expected b not to be cached")
+	}
+}
@@ -1,28 +1,195 @@
 package sql
 
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// TransactionMode is the SQLite locking mode a transaction is started with.
+type TransactionMode string
+
+const (
+	TransactionModeDeferred  TransactionMode = "DEFERRED"
+	TransactionModeImmediate TransactionMode = "IMMEDIATE"
+	TransactionModeExclusive TransactionMode = "EXCLUSIVE"
+)
+
 type Transaction struct {
+	conn       *Conn
 	connection *Connection
+	done       bool
+	generation int
 	id         string
 	pool       *ConnectionPool
 }
 
-func NewTransaction(id string, pool *ConnectionPool, connection *Connection) *Transaction {
+// Open a transaction on the server. The transaction is pinned to the given
+// connection until it is committed or rolled back so that every statement is
+// sent over the same stream with the transaction id assigned by the server.
+func BeginTransaction(
+	ctx context.Context,
+	conn *Conn,
+	pool *ConnectionPool,
+	connection *Connection,
+	mode TransactionMode,
+) (*Transaction, error) {
+	response, err := conn.sendOver(ctx, connection, Query{
+		ID:        uuid.NewString(),
+		Statement: fmt.Sprintf("BEGIN %s", mode),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Error) > 0 {
+		return nil, response.err()
+	}
+
+	if len(response.Data.TransactionId) == 0 {
+		return nil, errors.New("server did not return a transaction id")
+	}
+
+	transaction := NewTransaction(
+		string(response.Data.TransactionId),
+		conn,
+		pool,
+		connection,
+	)
+
+	// The transaction lives on the server side of the current stream.
+	transaction.generation = connection.streamGeneration()
+
+	return transaction, nil
+}
+
+func NewTransaction(id string, conn *Conn, pool *ConnectionPool, connection *Connection) *Transaction {
 	return &Transaction{
+		conn:       conn,
 		connection: connection,
 		id:         id,
 		pool:       pool,
 	}
 }
 
-func (t *Transaction) Commit() error {
-	// Implement commit logic
-	t.pool.Put(t.connection)
+// Report whether the transaction can still be used on the stream it was
+// started on. After a reconnect the server no longer knows the transaction.
+// Nothing more is written to a stream the server drains either, so a
+// transaction that is open when the server drains its stream is lost as well
+// and fails with driver.ErrBadConn.
+func (t *Transaction) valid() bool {
+	return !t.connection.isClosed() && t.connection.streamGeneration() == t.generation
+}
 
-	return nil
+func (t *Transaction) Commit() error {
+	return t.end("COMMIT")
 }
 
 func (t *Transaction) Rollback() error {
-	t.pool.Put(t.connection)
+	return t.end("ROLLBACK")
+}
+
+// End the transaction on the server and release the connection back to the
+// pool. An error is returned when the server has already aborted the
+// transaction or refuses to end it.
+func (t *Transaction) end(statement string) error {
+	if t.done {
+		return errors.New("transaction has already been committed or rolled back")
+	}
+
+	t.done = true
+
+	defer func() {
+		t.pool.Put(t.connection)
+
+		if t.conn.transaction == t {
+			t.conn.transaction = nil
+		}
+	}()
+
+	// The server rolled the transaction back when its stream ended.
+	if !t.valid() {
+		return badConn(fmt.Errorf("transaction %s was lost when its stream ended", t.id))
+	}
+
+	err := t.send(statement)
+
+	// SQLite keeps the transaction open when COMMIT fails, e.g. when the
+	// database is busy, so it is rolled back before the connection is reused.
+	if statement == "COMMIT" && errors.As(err, new(*Error)) {
+		if rollbackErr := t.send("ROLLBACK"); rollbackErr != nil {
+			return fmt.Errorf("%w, rolling back: %w", err, rollbackErr)
+		}
+	}
+
+	return err
+}
+
+// Send a statement of the transaction. Commit and Rollback take no context,
+// so the statement is bounded by the query timeout, or
+// DefaultTransactionEndTimeout without one.
+func (t *Transaction) send(statement string) error {
+	timeout := t.conn.config.QueryTimeout
+
+	if timeout <= 0 {
+		timeout = DefaultTransactionEndTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := t.conn.sendOver(ctx, t.connection, Query{
+		ID:            uuid.NewString(),
+		Statement:     statement,
+		TransactionID: t.id,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(response.Error) > 0 {
+		return fmt.Errorf("transaction %s: %w", t.id, response.err())
+	}
 
 	return nil
 }
+
+// Map the database/sql transaction options onto a SQLite transaction mode.
+//
+// SQLite transactions are always serializable, so the weaker isolation levels
+// are satisfied by a deferred transaction. Serializable takes the write lock up
+// front with an immediate transaction and linearizable also keeps readers out
+// with an exclusive transaction. Read-only transactions never need the write
+// lock and always run deferred.
+func transactionMode(opts driver.TxOptions) (TransactionMode, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+
+	switch level {
+	case sql.LevelDefault,
+		sql.LevelReadUncommitted,
+		sql.LevelReadCommitted,
+		sql.LevelRepeatableRead,
+		sql.LevelSnapshot:
+		return TransactionModeDeferred, nil
+	case sql.LevelSerializable:
+		if opts.ReadOnly {
+			return TransactionModeDeferred, nil
+		}
+
+		return TransactionModeImmediate, nil
+	case sql.LevelLinearizable:
+		if opts.ReadOnly {
+			return TransactionModeDeferred, nil
+		}
+
+		return TransactionModeExclusive, nil
+	default:
+		return "", fmt.Errorf("unsupported isolation level: %s", level)
+	}
+}
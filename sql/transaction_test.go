@@ -0,0 +1,193 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Take the next query frame of a test connection and return the transaction
+// id and statement its query was encoded with.
+func nextTestQuery(t *testing.T, c *Connection) (string, string, *Frame) {
+	t.Helper()
+
+	frame := nextTestFrame(t, c)
+
+	if frame.messageType != QueryStreamFrame || len(frame.queries) != 1 {
+		t.Fatalf("expected a frame with 1 query, got %v with %d", frame.messageType, len(frame.queries))
+	}
+
+	query := frame.queries[0]
+	fields := make([]string, 3)
+
+	// The id, transaction id and statement are each prefixed by their length
+	for i := range fields {
+		length := binary.LittleEndian.Uint32(query)
+		fields[i] = string(query[4 : 4+length])
+		query = query[4+length:]
+	}
+
+	return fields[1], fields[2], frame
+}
+
+func TestTransaction(t *testing.T) {
+	c := newTestConnection(t)
+	conn := newTestConn(t, c)
+
+	begun := make(chan driver.Tx)
+
+	go func() {
+		transaction, err := conn.BeginTx(context.Background(), driver.TxOptions{})
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		begun <- transaction
+	}()
+
+	transactionID, statement, frame := nextTestQuery(t, c)
+
+	if transactionID != "" || statement != "BEGIN DEFERRED" {
+		t.Errorf("expected BEGIN DEFERRED outside a transaction, got %q in %q", statement, transactionID)
+	}
+
+	c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte(frame.IDs()[0]), TransactionId: []byte("transaction")}})
+
+	transaction := <-begun
+
+	if transaction == nil {
+		t.FailNow()
+	}
+
+	// Statements run inside the transaction the server assigned
+	executed := make(chan error)
+
+	go func() {
+		_, err := conn.ExecContext(context.Background(), "INSERT INTO users (name) VALUES ('a')", nil)
+		executed <- err
+	}()
+
+	transactionID, statement, frame = nextTestQuery(t, c)
+
+	if transactionID != "transaction" || statement != "INSERT INTO users (name) VALUES ('a')" {
+		t.Errorf("expected the insert in the transaction, got %q in %q", statement, transactionID)
+	}
+
+	c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte(frame.IDs()[0])}})
+
+	if err := <-executed; err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit the server refuses is followed by a rollback
+	committed := make(chan error)
+
+	go func() {
+		committed <- transaction.Commit()
+	}()
+
+	transactionID, statement, frame = nextTestQuery(t, c)
+
+	if transactionID != "transaction" || statement != "COMMIT" {
+		t.Errorf("expected COMMIT in the transaction, got %q in %q", statement, transactionID)
+	}
+
+	c.deliver(QueryResponse{
+		Data:      QueryResponseData{ID: []byte(frame.IDs()[0])},
+		Error:     []byte("database is locked"),
+		ErrorCode: ErrorCodeBusy,
+	})
+
+	transactionID, statement, frame = nextTestQuery(t, c)
+
+	if transactionID != "transaction" || statement != "ROLLBACK" {
+		t.Errorf("expected ROLLBACK in the transaction, got %q in %q", statement, transactionID)
+	}
+
+	c.deliver(QueryResponse{Data: QueryResponseData{ID: []byte(frame.IDs()[0])}})
+
+	if err := <-committed; !errors.Is(err, ErrBusy) {
+		t.Errorf("expected %v, got %v", ErrBusy, err)
+	}
+
+	if conn.transaction != nil {
+		t.Error("expected the transaction to be released")
+	}
+}
+
+func TestTransactionStreamDraining(t *testing.T) {
+	c := newTestConnection(t)
+
+	pool := &ConnectionPool{}
+	conn := &Conn{pool: pool}
+
+	transaction := NewTransaction("transaction", conn, pool, c)
+	transaction.generation = c.streamGeneration()
+	conn.transaction = transaction
+
+	if !transaction.valid() {
+		t.Fatal("expected the transaction to be valid")
+	}
+
+	c.streamDraining()
+
+	// Nothing more is written to the draining stream, the transaction is lost
+	if transaction.valid() || conn.IsValid() {
+		t.Error("expected the transaction to be invalid after the stream drained")
+	}
+
+	if err := transaction.Commit(); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected %v, got %v", driver.ErrBadConn, err)
+	}
+
+	if conn.transaction != nil {
+		t.Error("expected the transaction to be released")
+	}
+}
+
+func TestTransactionMode(t *testing.T) {
+	tests := []struct {
+		level    sql.IsolationLevel
+		readOnly bool
+		mode     TransactionMode
+	}{
+		{sql.LevelDefault, false, TransactionModeDeferred},
+		{sql.LevelDefault, true, TransactionModeDeferred},
+		{sql.LevelReadUncommitted, false, TransactionModeDeferred},
+		{sql.LevelReadUncommitted, true, TransactionModeDeferred},
+		{sql.LevelReadCommitted, false, TransactionModeDeferred},
+		{sql.LevelReadCommitted, true, TransactionModeDeferred},
+		{sql.LevelRepeatableRead, false, TransactionModeDeferred},
+		{sql.LevelRepeatableRead, true, TransactionModeDeferred},
+		{sql.LevelSnapshot, false, TransactionModeDeferred},
+		{sql.LevelSnapshot, true, TransactionModeDeferred},
+		{sql.LevelSerializable, false, TransactionModeImmediate},
+		{sql.LevelSerializable, true, TransactionModeDeferred},
+		{sql.LevelLinearizable, false, TransactionModeExclusive},
+		{sql.LevelLinearizable, true, TransactionModeDeferred},
+	}
+
+	for _, test := range tests {
+		mode, err := transactionMode(driver.TxOptions{Isolation: driver.IsolationLevel(test.level), ReadOnly: test.readOnly})
+
+		if err != nil {
+			t.Errorf("%s (read only %v): %v", test.level, test.readOnly, err)
+			continue
+		}
+
+		if mode != test.mode {
+			t.Errorf("%s (read only %v): expected %s, got %s", test.level, test.readOnly, test.mode, mode)
+		}
+	}
+
+	// SQLite has no level that matches write committed
+	for _, readOnly := range []bool{false, true} {
+		if _, err := transactionMode(driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelWriteCommitted), ReadOnly: readOnly}); err == nil {
+			t.Errorf("%s (read only %v): expected an error", sql.LevelWriteCommitted, readOnly)
+		}
+	}
+}
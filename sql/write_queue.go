@@ -2,7 +2,6 @@ package sql
 
 import (
 	"context"
-	"log"
 	"sync"
 )
 
@@ -12,6 +11,7 @@ type WriteQueue struct {
 	ctx        context.Context
 	frames     []*Frame
 	mutex      *sync.Mutex
+	wake       chan struct{}
 }
 
 func NewWriteQueue(connection *Connection) *WriteQueue {
@@ -23,6 +23,7 @@ func NewWriteQueue(connection *Connection) *WriteQueue {
 		ctx:        ctx,
 		frames:     []*Frame{},
 		mutex:      &sync.Mutex{},
+		wake:       make(chan struct{}, 1),
 	}
 
 	go w.work()
@@ -34,52 +35,92 @@ func (w *WriteQueue) Close() {
 	w.cancel()
 }
 
+// Write the queued frames whenever new frames are queued or the stream
+// connects, frames stay queued while the stream is not connected.
 func (w *WriteQueue) work() {
 	for {
 		select {
 		case <-w.ctx.Done():
 			return
-		default:
-			w.mutex.Lock()
-			w.connection.mutex.Lock()
-
-			if len(w.frames) > 0 {
-				frame := w.frames[0]
+		case <-w.wake:
+			for w.writeNext() {
+			}
+		}
+	}
+}
 
-				// Encode the frame with chunk signature
-				encodedFrame, newSignature := frame.EncodeWithSignature(
-					w.connection.accessKeySecret,
-					w.connection.date,
-					w.connection.previousSignature,
-				)
+// Wake the worker up to write the queued frames.
+func (w *WriteQueue) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
 
-				_, err := w.connection.writer.Write(encodedFrame)
+// Write the first queued frame to the stream. Returns false when there is no
+// frame to write or the stream is not connected.
+func (w *WriteQueue) writeNext() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-				if err != nil {
-					log.Println("Error writing request:", err)
-					// TODO: Handle error
-				}
+	c := w.connection
 
-				err = w.connection.writer.Flush()
+	c.mutex.Lock()
 
-				if err != nil {
-					log.Println("Error flushing buffer:", err)
-					// TODO: Handle error
-				}
+	if len(w.frames) == 0 || !c.streaming {
+		c.mutex.Unlock()
+		return false
+	}
 
-				// Update the previous signature for the next chunk
-				w.connection.previousSignature = newSignature
+	frame := w.frames[0]
+	w.frames = w.frames[1:]
 
-				w.frames = w.frames[1:]
-			}
+	if frame.written != nil {
+		defer close(frame.written)
+	}
 
-			w.connection.mutex.Unlock()
-			w.mutex.Unlock()
+	// From here on the frame may reach the server, even when writing it fails,
+	// so its queries are no longer safe to send again.
+	for _, id := range frame.IDs() {
+		if pendingQuery, ok := c.responses[id]; ok {
+			pendingQuery.written = true
+			pendingQuery.generation = c.generation
 		}
 	}
+
+	// Encode the frame with chunk signature
+	encodedFrame, newSignature := frame.EncodeWithSignature(
+		c.accessKeySecret,
+		c.date,
+		c.previousSignature,
+	)
+
+	// Update the previous signature for the next chunk
+	c.previousSignature = newSignature
+	writer := c.writer
+
+	c.mutex.Unlock()
+
+	// A failed write ends the stream, the connection notices that when
+	// reading from it fails and reconnects.
+	_, err := writer.Write(encodedFrame)
+
+	if err != nil {
+		c.config.Logger.Println("Error writing request:", err)
+		return true
+	}
+
+	err = writer.Flush()
+
+	if err != nil {
+		c.config.Logger.Println("Error flushing buffer:", err)
+	}
+
+	return true
 }
 
-func (w *WriteQueue) Write(query []byte) {
+// Queue a query request. The id is the one the server responds with.
+func (w *WriteQueue) Write(id string, query []byte) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -99,5 +140,53 @@ func (w *WriteQueue) Write(query []byte) {
 		w.frames = append(w.frames, writingFrame)
 	}
 
-	writingFrame.AddQuery(query)
+	writingFrame.AddQuery(id, query)
+
+	w.notify()
+}
+
+// Queue a control message. Control messages are written in order with the
+// frames queued before them so the signature chain stays intact. The id is
+// the one the server responds to the message with, or empty when it does not
+// respond.
+func (w *WriteQueue) WriteMessage(messageType QueryStreamMessageType, id string, payload []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.frames = append(w.frames, NewMessageFrame(messageType, id, payload))
+
+	w.notify()
+}
+
+// Queue a control message and wait until it and the frames queued before it
+// were written, or the context ends.
+func (w *WriteQueue) writeMessageAndWait(ctx context.Context, messageType QueryStreamMessageType, payload []byte) error {
+	frame := NewMessageFrame(messageType, "", payload)
+	frame.written = make(chan struct{})
+
+	w.mutex.Lock()
+	w.frames = append(w.frames, frame)
+	w.notify()
+	w.mutex.Unlock()
+
+	select {
+	case <-frame.written:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drop the queued queries and messages the keep function returns false for.
+// The caller must hold the mutex.
+func (w *WriteQueue) retain(keep func(id string) bool) {
+	frames := w.frames[:0]
+
+	for _, frame := range w.frames {
+		if frame.retain(keep) {
+			frames = append(frames, frame)
+		}
+	}
+
+	w.frames = frames
 }